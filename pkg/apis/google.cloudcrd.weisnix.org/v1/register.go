@@ -32,6 +32,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&Project{},
 		&ProjectList{},
+		&ClusterProject{},
+		&ClusterProjectList{},
 		&Instance{},
 		&InstanceList{},
 		&Database{},
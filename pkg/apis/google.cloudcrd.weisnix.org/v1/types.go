@@ -1,11 +1,43 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// The type of a Condition. Every object has a Ready and a Synced condition.
+type ConditionType string
+
+const (
+	// Ready is true when the resource in Google Cloud exists and is usable.
+	ConditionReady ConditionType = "Ready"
+	// Synced is true when the last reconcile of the object succeeded.
+	ConditionSynced ConditionType = "Synced"
+	// UpToDate is false when the spec has changes that are not applied to the resource in
+	// Google Cloud.
+	ConditionUpToDate ConditionType = "UpToDate"
+	// Expiring is true when the resource is about to be deleted because it expires.
+	ConditionExpiring ConditionType = "Expiring"
+)
+
+type Condition struct {
+	Type               ConditionType          `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// ResourceStatus holds the status fields common to all kinds.
+type ResourceStatus struct {
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	Conditions         []Condition `json:"conditions,omitempty"`
+	SelfLink           string      `json:"selfLink,omitempty"`
+	ID                 string      `json:"id,omitempty"`
+	LastError          string      `json:"lastError,omitempty"`
+}
+
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // A Google Cloud project
@@ -23,10 +55,80 @@ type ProjectSpec struct {
 	Zone                 string `json:"zone"`
 	ServiceAccount       string `json:"serviceaccount"`
 	ServiceAccountSecret string `json:"serviceaccountsecret"`
+
+	// Where the controller gets its credentials for the project from. Without it, the JSON
+	// key in the field 'json' of the Secret serviceaccountsecret is used.
+	Credentials *ProjectCredentials `json:"credentials,omitempty"`
+
+	// The deletion policy of Instances and Databases in the project that do not set one.
+	// Defaults to Delete.
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// AdoptPolicy says whether an object takes over an existing resource in Google Cloud. A
+// resource that belongs to another object is never adopted.
+type AdoptPolicy string
+
+const (
+	// Do not adopt the resource, fail instead.
+	AdoptPolicyNever AdoptPolicy = "Never"
+	// Adopt the resource if it matches the spec, fail otherwise.
+	AdoptPolicyVerify AdoptPolicy = "Verify"
+	// Adopt the resource and change it to match the spec.
+	AdoptPolicyUpdate AdoptPolicy = "Update"
+)
+
+// DeletionPolicy says what happens to the resource in Google Cloud when its object is deleted.
+type DeletionPolicy string
+
+const (
+	// Delete the resource.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// Keep the resource as it is.
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// Keep the resource, but remove the labels that tie it to the object.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+type CredentialSource string
+
+const (
+	// A JSON key of a service account, stored in a Secret in the namespace of the Project.
+	CredentialSourceSecret CredentialSource = "Secret"
+	// The Application Default Credentials of the controller, e.g. from Workload Identity.
+	// Only for ClusterProjects.
+	CredentialSourceApplicationDefault CredentialSource = "ApplicationDefault"
+	// Impersonation of a service account through the IAM Credentials API, authenticated
+	// with the Application Default Credentials of the controller. Only for ClusterProjects.
+	CredentialSourceImpersonation CredentialSource = "Impersonation"
+)
+
+type ProjectCredentials struct {
+	Source CredentialSource `json:"source"`
+
+	// For source Secret. The name defaults to serviceaccountsecret, the key to 'json'.
+	SecretName string `json:"secretName,omitempty"`
+	SecretKey  string `json:"secretKey,omitempty"`
+
+	// For source Impersonation. The email of the service account to impersonate, and an
+	// optional chain of service accounts to impersonate through, in order.
+	ImpersonateServiceAccount string   `json:"impersonateServiceAccount,omitempty"`
+	Delegates                 []string `json:"delegates,omitempty"`
 }
 
 type ProjectStatus struct {
+	ResourceStatus `json:",inline"`
+
+	ProjectNumber string `json:"projectNumber,omitempty"`
+	// Permissions the service account needs, but does not have on the project.
+	MissingPermissions []string `json:"missingPermissions,omitempty"`
 
+	// When the project was last checked against Google Cloud, and the generation and
+	// credentials that were checked. It is checked again when either changes, or after an
+	// hour.
+	VerifiedAt          *metav1.Time `json:"verifiedAt,omitempty"`
+	VerifiedGeneration  int64        `json:"verifiedGeneration,omitempty"`
+	VerifiedCredentials string       `json:"verifiedCredentials,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -40,7 +142,44 @@ type ProjectList struct {
 }
 
 // +genclient
-// +genclient:noStatus
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// A Google Cloud project that Instances and Databases in several namespaces can use. The
+// credentials are read from the namespace of the controller.
+type ClusterProject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterProjectSpec `json:"spec"`
+	Status ProjectStatus      `json:"status"`
+}
+
+type ClusterProjectSpec struct {
+	ProjectSpec `json:",inline"`
+
+	// The namespaces that may use the project. Without it, no namespace may.
+	AllowedNamespaces *AllowedNamespaces `json:"allowedNamespaces,omitempty"`
+}
+
+// AllowedNamespaces selects namespaces by name or by label. A namespace is allowed if it
+// matches either.
+type AllowedNamespaces struct {
+	Names    []string              `json:"names,omitempty"`
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterProjectList is a list of ClusterProject resources
+type ClusterProjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ClusterProject `json:"items"`
+}
+
+// +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // A Google Compute Instance
@@ -48,14 +187,233 @@ type Instance struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec InstanceSpec `json:"spec"`
+	Spec   InstanceSpec   `json:"spec"`
+	Status InstanceStatus `json:"status"`
 }
 
 type InstanceSpec struct {
 	Project string `json:"project"`
 	Type     string `json:"type"`
+	// The image of the boot disk. Either an image path like
+	// "projects/debian-cloud/global/images/debian-12-bookworm-v20240110", an image family as
+	// "project/family", or a family of a public image like "debian-12".
 	Image    string `json:"image"`
 	DiskSize int64    `json:"disksize"`
+	// The zone of the VM, in the region of the project. Defaults to the zone of the project.
+	Zone string `json:"zone,omitempty"`
+	// The service account the VM runs as. Defaults to the service account of the project
+	// with access to Cloud Storage and Compute Engine.
+	ServiceAccount *InstanceServiceAccount `json:"serviceAccount,omitempty"`
+	// Whether the VM should be running, stopped or suspended. Defaults to Running.
+	RunState RunState `json:"runState,omitempty"`
+	// When the VM is started and stopped. Only applies while the runState is Running.
+	Schedule *Schedule `json:"schedule,omitempty"`
+	// When the Instance is deleted automatically.
+	Expiry *Expiry `json:"expiry,omitempty"`
+	// What happens to the VM when the Instance is deleted. Defaults to the deletion policy
+	// of the project.
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// Whether Compute Engine refuses to delete the VM.
+	DeletionProtection bool `json:"deletionProtection,omitempty"`
+
+	// The name of the VM, if it differs from the name of the Instance. Can not be changed
+	// once the VM is created or adopted.
+	ExternalName string `json:"externalName,omitempty"`
+	// What to do when a VM of that name already exists that the controller did not create.
+	// Defaults to Never.
+	AdoptPolicy AdoptPolicy `json:"adoptPolicy,omitempty"`
+	// How the VM is provisioned and what happens when it is preempted or its host is under
+	// maintenance. Defaults to a standard VM that is migrated and restarted automatically.
+	Scheduling *Scheduling `json:"scheduling,omitempty"`
+	// Whether a VM that is created again keeps the image it was first created from, or gets
+	// the latest image of the family. Defaults to Pin.
+	ImagePolicy ImagePolicy `json:"imagePolicy,omitempty"`
+
+	// Labels of the VM.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Network tags of the VM.
+	Tags []string `json:"tags,omitempty"`
+	// The network interfaces of the VM, the first one is the primary. Defaults to one in the
+	// subnetwork "default" with an ephemeral external IP. Changes are not applied to an
+	// existing VM.
+	NetworkInterfaces []NetworkInterface `json:"networkInterfaces,omitempty"`
+	// Metadata of the VM, e.g. a "startup-script" or cloud-init "user-data".
+	Metadata []MetadataItem `json:"metadata,omitempty"`
+	// SSH keys that are added to the "ssh-keys" metadata of the VM.
+	SSHKeys []SSHKey `json:"sshKeys,omitempty"`
+
+	// What to do when a field that can not be changed on an existing VM, like the image,
+	// changes. Defaults to Warn.
+	UpdatePolicy UpdatePolicy `json:"updatePolicy,omitempty"`
+}
+
+type InstanceServiceAccount struct {
+	// The email of the service account, or "None" to run the VM without a service account.
+	// Defaults to the service account of the project.
+	Email string `json:"email,omitempty"`
+	// The OAuth scopes of the VM, as URLs or short names like "devstorage.read_only".
+	// Defaults to "cloud-platform", so access is controlled by the IAM roles of the service
+	// account alone.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+const ServiceAccountNone = "None"
+
+type Scheduling struct {
+	// Standard, Spot or Preemptible. Defaults to Standard.
+	ProvisioningModel ProvisioningModel `json:"provisioningModel,omitempty"`
+	// MIGRATE or TERMINATE. Defaults to MIGRATE for standard VMs, Spot and preemptible VMs
+	// must TERMINATE.
+	OnHostMaintenance string `json:"onHostMaintenance,omitempty"`
+	// Whether Compute Engine restarts the VM when it crashes or is terminated for
+	// maintenance. Defaults to true for standard VMs, Spot and preemptible VMs can not be
+	// restarted automatically.
+	AutomaticRestart *bool `json:"automaticRestart,omitempty"`
+	// STOP or DELETE, what happens to a Spot VM when it is preempted, or to any VM when its
+	// maximum run duration is over. Defaults to STOP.
+	InstanceTerminationAction string `json:"instanceTerminationAction,omitempty"`
+	// How long the VM may run before it is terminated, e.g. "4h".
+	MaxRunDuration *metav1.Duration `json:"maxRunDuration,omitempty"`
+	// What the controller does with a VM that was stopped by Compute Engine, e.g. because
+	// it was preempted. VMs that were deleted are always created again, and VMs with a
+	// runState of Running or a schedule always started again. Defaults to Never.
+	RestartPolicy RestartPolicy `json:"restartPolicy,omitempty"`
+}
+
+type ProvisioningModel string
+
+const (
+	ProvisioningModelStandard    ProvisioningModel = "Standard"
+	ProvisioningModelSpot        ProvisioningModel = "Spot"
+	ProvisioningModelPreemptible ProvisioningModel = "Preemptible"
+)
+
+type RestartPolicy string
+
+const (
+	// Start the VM again.
+	RestartPolicyAlways RestartPolicy = "Always"
+	// Leave the VM stopped.
+	RestartPolicyNever RestartPolicy = "Never"
+)
+
+type NetworkInterface struct {
+	// The name of the subnetwork in the region of the project. Defaults to "default".
+	Subnetwork string `json:"subnetwork,omitempty"`
+	// The name of the network. Only needed if the subnetwork name is not unique.
+	Network string `json:"network,omitempty"`
+	// The project the network belongs to, for Shared VPC. Defaults to the project of the
+	// Instance.
+	HostProject string `json:"hostProject,omitempty"`
+
+	// A static internal IP, or the name of a reserved internal address. Defaults to an
+	// ephemeral one.
+	InternalIP string `json:"internalIP,omitempty"`
+	// "None" for no external IP, "Ephemeral", or a static external IP or the name of a
+	// reserved external address. Defaults to Ephemeral on the first interface, and to None
+	// on all others.
+	ExternalIP string `json:"externalIP,omitempty"`
+}
+
+const (
+	ExternalIPNone      = "None"
+	ExternalIPEphemeral = "Ephemeral"
+)
+
+type MetadataItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	// Takes the value from a ConfigMap or Secret in the namespace of the Instance instead.
+	ValueFrom *MetadataSource `json:"valueFrom,omitempty"`
+}
+
+// MetadataSource selects a key of either a ConfigMap or a Secret.
+type MetadataSource struct {
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	SecretKeyRef    *corev1.SecretKeySelector    `json:"secretKeyRef,omitempty"`
+}
+
+// SSHKey are the public keys of a user, one per line, in a key of a Secret.
+type SSHKey struct {
+	User         string                   `json:"user"`
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+}
+
+type RunState string
+
+const (
+	RunStateRunning   RunState = "Running"
+	RunStateStopped   RunState = "Stopped"
+	RunStateSuspended RunState = "Suspended"
+)
+
+// Schedule starts and stops a resource at fixed times, for example to turn it off outside of
+// working hours.
+type Schedule struct {
+	// When to start the resource, as a cron expression like "0 8 * * 1-5".
+	Start string `json:"start"`
+	// When to stop the resource, as a cron expression like "0 18 * * 1-5".
+	Stop string `json:"stop"`
+	// The time zone of the cron expressions, e.g. "Europe/Berlin". Defaults to UTC.
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Expiry deletes an object, and with it the resource in Google Cloud, at a given time. The
+// deadline can be extended by changing it.
+type Expiry struct {
+	// When the object is deleted.
+	At *metav1.Time `json:"at,omitempty"`
+	// How long after its creation the object is deleted, e.g. "72h".
+	After *metav1.Duration `json:"after,omitempty"`
+}
+
+type ImagePolicy string
+
+const (
+	// Create the VM again from the image recorded in the status.
+	ImagePolicyPin ImagePolicy = "Pin"
+	// Resolve the image family again when the VM is created again.
+	ImagePolicyFollow ImagePolicy = "Follow"
+)
+
+type UpdatePolicy string
+
+const (
+	// Delete the VM and create it again with the new spec.
+	UpdatePolicyRecreate UpdatePolicy = "Recreate"
+	// Leave the VM as it is.
+	UpdatePolicyIgnore UpdatePolicy = "Ignore"
+	// Leave the VM as it is, but emit a Warning event and set UpToDate to false.
+	UpdatePolicyWarn UpdatePolicy = "Warn"
+)
+
+type InstanceStatus struct {
+	ResourceStatus `json:",inline"`
+
+	// The name of the Project or ClusterProject the instance was created in.
+	Project string `json:"project,omitempty"`
+	// The status of the VM as reported by Compute Engine, e.g. PROVISIONING, RUNNING or
+	// TERMINATED.
+	State string `json:"state,omitempty"`
+	// The zone the VM was created in.
+	Zone string `json:"zone,omitempty"`
+	// The name of the VM, recorded when it is created or adopted.
+	ExternalName string `json:"externalName,omitempty"`
+	// The run state the controller last put the VM in.
+	RunState RunState `json:"runState,omitempty"`
+	// The machine type the VM is running with.
+	MachineType string `json:"machineType,omitempty"`
+	// The image the VM was created from, as given in the spec.
+	Image string `json:"image,omitempty"`
+	// The URL of the image the boot disk was created from.
+	SourceImage string `json:"sourceImage,omitempty"`
+	// When the VM was created.
+	CreationTime *metav1.Time `json:"creationTime,omitempty"`
+	// When the Instance is deleted because it expires.
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// The internal and external IPs of the VM.
+	InternalIPs []string `json:"internalIPs,omitempty"`
+	ExternalIPs []string `json:"externalIPs,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -69,7 +427,6 @@ type InstanceList struct {
 }
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // A Managed Database
@@ -77,13 +434,69 @@ type Database struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec DatabaseSpec `json:"spec"`
+	Spec   DatabaseSpec   `json:"spec"`
+	Status DatabaseStatus `json:"status"`
 }
 
 type DatabaseSpec struct {
 	Project string `json:"project"`
-	Type     string `json:"type"`
-	AuthorizedNetworks []string  `json:"authorizednetworks"`
+	// The database engine, MYSQL or POSTGRES. Defaults to MYSQL.
+	Type string `json:"type"`
+	// The version of the engine, e.g. "8.0" or "16". Defaults to the newest one supported.
+	Version string `json:"version,omitempty"`
+	// The machine type, e.g. db-n1-standard-1 or db-custom-2-7680. Defaults to
+	// db-n1-standard-1 for MYSQL and db-custom-1-3840 for POSTGRES, which only supports
+	// custom machine types.
+	Tier string `json:"tier,omitempty"`
+	// PD_SSD or PD_HDD. Defaults to PD_SSD.
+	StorageType string `json:"storageType,omitempty"`
+	// The size of the data disk in GB, at least 10. Defaults to 10.
+	DiskSize int64 `json:"disksize,omitempty"`
+	// Whether the disk grows automatically when it is running full. Defaults to true.
+	StorageAutoResize *bool `json:"storageAutoResize,omitempty"`
+	// ZONAL or REGIONAL, for high availability. Defaults to ZONAL.
+	AvailabilityType string `json:"availabilityType,omitempty"`
+
+	// Networks in CIDR notation that may connect to the database.
+	AuthorizedNetworks []string `json:"authorizednetworks"`
+	// Instances in the namespace of the Database whose external IPs may connect to it.
+	InstanceSelector *metav1.LabelSelector `json:"instanceSelector,omitempty"`
+
+	// The name of the Secret with the address and credentials of the database. Defaults to
+	// the name of the Database with "-connection" appended. For an adopted Cloud SQL
+	// instance, the Secret has to be created with the username and password of one of its
+	// users, the controller adds the address.
+	ConnectionSecret string `json:"connectionSecret,omitempty"`
+
+	// When the database is started and stopped, by setting its activation policy.
+	Schedule *Schedule `json:"schedule,omitempty"`
+	// When the Database is deleted automatically.
+	Expiry *Expiry `json:"expiry,omitempty"`
+	// What happens to the Cloud SQL instance when the Database is deleted. Defaults to the
+	// deletion policy of the project.
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// Whether Cloud SQL refuses to delete the instance.
+	DeletionProtection bool `json:"deletionProtection,omitempty"`
+
+	// The name of the Cloud SQL instance, if it differs from the name of the Database. Can
+	// not be changed once the Cloud SQL instance is created or adopted.
+	ExternalName string `json:"externalName,omitempty"`
+	// What to do when a Cloud SQL instance of that name already exists that the controller
+	// did not create. Defaults to Never.
+	AdoptPolicy AdoptPolicy `json:"adoptPolicy,omitempty"`
+}
+
+type DatabaseStatus struct {
+	ResourceStatus `json:",inline"`
+
+	// The name of the Project or ClusterProject the database was created in.
+	Project string `json:"project,omitempty"`
+	// The name of the Cloud SQL instance, recorded when it is created or adopted.
+	ExternalName string `json:"externalName,omitempty"`
+	// The name of the Secret with the address and credentials of the database.
+	ConnectionSecret string `json:"connectionSecret,omitempty"`
+	// When the Database is deleted because it expires.
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -37,6 +37,7 @@ type DatabasesGetter interface {
 type DatabaseInterface interface {
 	Create(*v1.Database) (*v1.Database, error)
 	Update(*v1.Database) (*v1.Database, error)
+	UpdateStatus(*v1.Database) (*v1.Database, error)
 	Delete(name string, options *meta_v1.DeleteOptions) error
 	DeleteCollection(options *meta_v1.DeleteOptions, listOptions meta_v1.ListOptions) error
 	Get(name string, options meta_v1.GetOptions) (*v1.Database, error)
@@ -120,6 +121,22 @@ func (c *databases) Update(database *v1.Database) (result *v1.Database, err erro
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *databases) UpdateStatus(database *v1.Database) (result *v1.Database, err error) {
+	result = &v1.Database{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("databases").
+		Name(database.Name).
+		SubResource("status").
+		Body(database).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the database and deletes it. Returns an error if one occurs.
 func (c *databases) Delete(name string, options *meta_v1.DeleteOptions) error {
 	return c.client.Delete().
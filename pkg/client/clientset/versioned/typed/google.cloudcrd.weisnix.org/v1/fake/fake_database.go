@@ -100,6 +100,18 @@ func (c *FakeDatabases) Update(database *google_cloudcrd_weisnix_org_v1.Database
 	return obj.(*google_cloudcrd_weisnix_org_v1.Database), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeDatabases) UpdateStatus(database *google_cloudcrd_weisnix_org_v1.Database) (*google_cloudcrd_weisnix_org_v1.Database, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(databasesResource, "status", c.ns, database), &google_cloudcrd_weisnix_org_v1.Database{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*google_cloudcrd_weisnix_org_v1.Database), err
+}
+
 // Delete takes name of the database and deletes it. Returns an error if one occurs.
 func (c *FakeDatabases) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
@@ -28,6 +28,10 @@ type FakeGoogleV1 struct {
 	*testing.Fake
 }
 
+func (c *FakeGoogleV1) ClusterProjects() v1.ClusterProjectInterface {
+	return &FakeClusterProjects{c}
+}
+
 func (c *FakeGoogleV1) Databases(namespace string) v1.DatabaseInterface {
 	return &FakeDatabases{c, namespace}
 }
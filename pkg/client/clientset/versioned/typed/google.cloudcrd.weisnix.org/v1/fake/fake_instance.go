@@ -100,6 +100,18 @@ func (c *FakeInstances) Update(instance *google_cloudcrd_weisnix_org_v1.Instance
 	return obj.(*google_cloudcrd_weisnix_org_v1.Instance), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeInstances) UpdateStatus(instance *google_cloudcrd_weisnix_org_v1.Instance) (*google_cloudcrd_weisnix_org_v1.Instance, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(instancesResource, "status", c.ns, instance), &google_cloudcrd_weisnix_org_v1.Instance{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*google_cloudcrd_weisnix_org_v1.Instance), err
+}
+
 // Delete takes name of the instance and deletes it. Returns an error if one occurs.
 func (c *FakeInstances) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
@@ -100,6 +100,18 @@ func (c *FakeProjects) Update(project *google_cloudcrd_weisnix_org_v1.Project) (
 	return obj.(*google_cloudcrd_weisnix_org_v1.Project), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeProjects) UpdateStatus(project *google_cloudcrd_weisnix_org_v1.Project) (*google_cloudcrd_weisnix_org_v1.Project, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(projectsResource, "status", c.ns, project), &google_cloudcrd_weisnix_org_v1.Project{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*google_cloudcrd_weisnix_org_v1.Project), err
+}
+
 // Delete takes name of the project and deletes it. Returns an error if one occurs.
 func (c *FakeProjects) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
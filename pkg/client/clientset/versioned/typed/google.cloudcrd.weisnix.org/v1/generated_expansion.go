@@ -18,6 +18,8 @@ limitations under the License.
 
 package v1
 
+type ClusterProjectExpansion interface{}
+
 type DatabaseExpansion interface{}
 
 type InstanceExpansion interface{}
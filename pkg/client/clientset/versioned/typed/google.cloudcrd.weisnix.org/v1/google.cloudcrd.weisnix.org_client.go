@@ -27,6 +27,7 @@ import (
 
 type GoogleV1Interface interface {
 	RESTClient() rest.Interface
+	ClusterProjectsGetter
 	DatabasesGetter
 	InstancesGetter
 	ProjectsGetter
@@ -37,6 +38,10 @@ type GoogleV1Client struct {
 	restClient rest.Interface
 }
 
+func (c *GoogleV1Client) ClusterProjects() ClusterProjectInterface {
+	return newClusterProjects(c)
+}
+
 func (c *GoogleV1Client) Databases(namespace string) DatabaseInterface {
 	return newDatabases(c, namespace)
 }
@@ -37,6 +37,7 @@ type InstancesGetter interface {
 type InstanceInterface interface {
 	Create(*v1.Instance) (*v1.Instance, error)
 	Update(*v1.Instance) (*v1.Instance, error)
+	UpdateStatus(*v1.Instance) (*v1.Instance, error)
 	Delete(name string, options *meta_v1.DeleteOptions) error
 	DeleteCollection(options *meta_v1.DeleteOptions, listOptions meta_v1.ListOptions) error
 	Get(name string, options meta_v1.GetOptions) (*v1.Instance, error)
@@ -120,6 +121,22 @@ func (c *instances) Update(instance *v1.Instance) (result *v1.Instance, err erro
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *instances) UpdateStatus(instance *v1.Instance) (result *v1.Instance, err error) {
+	result = &v1.Instance{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("instances").
+		Name(instance.Name).
+		SubResource("status").
+		Body(instance).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the instance and deletes it. Returns an error if one occurs.
 func (c *instances) Delete(name string, options *meta_v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -37,6 +37,7 @@ type ProjectsGetter interface {
 type ProjectInterface interface {
 	Create(*v1.Project) (*v1.Project, error)
 	Update(*v1.Project) (*v1.Project, error)
+	UpdateStatus(*v1.Project) (*v1.Project, error)
 	Delete(name string, options *meta_v1.DeleteOptions) error
 	DeleteCollection(options *meta_v1.DeleteOptions, listOptions meta_v1.ListOptions) error
 	Get(name string, options meta_v1.GetOptions) (*v1.Project, error)
@@ -120,6 +121,22 @@ func (c *projects) Update(project *v1.Project) (result *v1.Project, err error) {
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *projects) UpdateStatus(project *v1.Project) (result *v1.Project, err error) {
+	result = &v1.Project{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("projects").
+		Name(project.Name).
+		SubResource("status").
+		Body(project).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the project and deletes it. Returns an error if one occurs.
 func (c *projects) Delete(name string, options *meta_v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -24,6 +24,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ClusterProjects returns a ClusterProjectInformer.
+	ClusterProjects() ClusterProjectInformer
 	// Databases returns a DatabaseInformer.
 	Databases() DatabaseInformer
 	// Instances returns a InstanceInformer.
@@ -43,6 +45,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ClusterProjects returns a ClusterProjectInformer.
+func (v *version) ClusterProjects() ClusterProjectInformer {
+	return &clusterProjectInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // Databases returns a DatabaseInformer.
 func (v *version) Databases() DatabaseInformer {
 	return &databaseInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
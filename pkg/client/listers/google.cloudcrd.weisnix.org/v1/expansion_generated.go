@@ -18,6 +18,10 @@ limitations under the License.
 
 package v1
 
+// ClusterProjectListerExpansion allows custom methods to be added to
+// ClusterProjectLister.
+type ClusterProjectListerExpansion interface{}
+
 // DatabaseListerExpansion allows custom methods to be added to
 // DatabaseLister.
 type DatabaseListerExpansion interface{}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/sqladmin/v1beta4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+const computeAPIPrefix = "https://www.googleapis.com/compute/v1/"
+
+// Set on a database that adopted its Cloud SQL instance. The password of its user is never
+// changed by the controller.
+const adoptedAnnotation = "google.cloudcrd.weisnix.org/adopted"
+
+// instanceExternalName returns the name of the VM of an instance. Once the VM is created or
+// adopted, that is the name recorded in the status.
+func instanceExternalName(instance *googlev1.Instance) string {
+	if instance.Status.ExternalName != "" {
+		return instance.Status.ExternalName
+	}
+	if instance.Spec.ExternalName != "" {
+		return instance.Spec.ExternalName
+	}
+	return instance.Name
+}
+
+// databaseExternalName returns the name of the Cloud SQL instance of a database. Once the
+// instance is created or adopted, that is the name recorded in the status.
+func databaseExternalName(database *googlev1.Database) string {
+	if database.Status.ExternalName != "" {
+		return database.Status.ExternalName
+	}
+	if database.Spec.ExternalName != "" {
+		return database.Spec.ExternalName
+	}
+	return database.Name
+}
+
+// checkExternalName fails permanently if the external name in the spec of an object differs
+// from the name recorded when its resource was created or adopted.
+func (c *Controller) checkExternalName(meta *metav1.ObjectMeta, kind, externalName, recorded string) error {
+	if externalName == "" {
+		externalName = meta.Name
+	}
+	if recorded == "" || recorded == externalName {
+		return nil
+	}
+
+	message := fmt.Sprintf("externalName of %s '%s' can not be changed from '%s' to '%s'", strings.ToLower(kind), meta.Name, recorded, externalName)
+	_ = c.MakeEvent(meta, kind, message, true)
+	return NewPermanentError("%s", message)
+}
+
+// managedBy returns the object, as "namespace/name", the labels tie a resource to, or an
+// empty string if the resource is not managed by the controller.
+func managedBy(labels map[string]string) string {
+	if labels[labelManagedBy] != managedByValue {
+		return ""
+	}
+	return labels[labelNamespace] + "/" + labels[labelName]
+}
+
+// ownsResource reports whether an object manages an existing resource with the given ID,
+// name and labels. Resources created before the labels were introduced are recognized by
+// their ID being recorded in the status of the object, or, if that was not recorded either,
+// by their name, as long as the object got its finalizer before. The finalizer is only added
+// once the object owns its resource, or is about to create it.
+func ownsResource(meta *metav1.ObjectMeta, status *googlev1.ResourceStatus, id, name, externalName string, labels map[string]string) bool {
+	if hasManagementLabels(meta, labels) {
+		return true
+	}
+	if managedBy(labels) != "" {
+		return false
+	}
+	if status.ID != "" {
+		return status.ID == id
+	}
+	return hasFinalizer(meta) && name == externalName
+}
+
+// checkAdoption decides whether an object may take over an existing resource it does not
+// own yet. diffs lists how the resource differs from the spec, immutable those of them that
+// can not be changed. All errors are permanent.
+func (c *Controller) checkAdoption(meta *metav1.ObjectMeta, kind, resource string, labels map[string]string, policy googlev1.AdoptPolicy, diffs, immutable []string) error {
+	var message string
+
+	if owner := managedBy(labels); owner != "" {
+		message = fmt.Sprintf("%s already exists and belongs to %s '%s'", resource, strings.ToLower(kind), owner)
+	} else {
+		switch policy {
+		case "", googlev1.AdoptPolicyNever:
+			message = fmt.Sprintf("%s already exists and was not created by %s '%s', set adoptPolicy to adopt it", resource, strings.ToLower(kind), meta.Name)
+		case googlev1.AdoptPolicyVerify:
+			if len(diffs) > 0 {
+				message = fmt.Sprintf("%s does not match the spec of %s '%s': %s", resource, strings.ToLower(kind), meta.Name, strings.Join(diffs, ", "))
+			}
+		case googlev1.AdoptPolicyUpdate:
+			if len(immutable) > 0 {
+				message = fmt.Sprintf("%s can not be changed to match the spec of %s '%s': %s", resource, strings.ToLower(kind), meta.Name, strings.Join(immutable, ", "))
+			}
+		default:
+			return NewPermanentError("invalid adoptPolicy '%s', must be Never, Verify or Update", policy)
+		}
+	}
+
+	if message != "" {
+		_ = c.MakeEvent(meta, kind, message, true)
+		return NewPermanentError("%s", message)
+	}
+
+	c.MakeEvent(meta, kind, fmt.Sprintf("adopting existing %s", resource), false)
+	return nil
+}
+
+// adoptInstance makes sure an existing VM belongs to the instance, or may be adopted by it.
+// Once adopted, the VM is labeled and updated like any other.
+func (c *Controller) adoptInstance(instance *googlev1.Instance, comp *compute.Service, project *googleProject, inst *compute.Instance) error {
+	if ownsResource(&instance.ObjectMeta, &instance.Status.ResourceStatus, strconv.FormatUint(inst.Id, 10), inst.Name, instanceExternalName(instance), inst.Labels) {
+		return nil
+	}
+
+	var diffs, immutable []string
+
+	if machineType := path.Base(inst.MachineType); machineType != instance.Spec.Type {
+		diffs = append(diffs, fmt.Sprintf("machine type is %s", machineType))
+	}
+
+	var tags []string
+	if inst.Tags != nil {
+		tags = inst.Tags.Items
+	}
+	if !stringsEqual(uniqueSorted(append([]string{}, tags...)), uniqueSorted(append([]string{}, instance.Spec.Tags...))) {
+		diffs = append(diffs, fmt.Sprintf("tags are [%s]", strings.Join(tags, ", ")))
+	}
+
+	if !labelsEqual(unmanagedLabels(inst.Labels), unmanagedLabels(instance.Spec.Labels)) {
+		diffs = append(diffs, "labels differ")
+	}
+
+	var metadata []*compute.MetadataItems
+	if inst.Metadata != nil {
+		metadata = inst.Metadata.Items
+	}
+	desiredMetadata, err := c.instanceMetadata(instance)
+	if err != nil {
+		return err
+	}
+	if !metadataEqual(metadata, desiredMetadata) {
+		var keys []string
+		for _, item := range metadata {
+			keys = append(keys, item.Key)
+		}
+		diffs = append(diffs, fmt.Sprintf("metadata keys are [%s]", strings.Join(keys, ", ")))
+	}
+
+	serviceAccounts, err := instanceServiceAccounts(instance, project)
+	if err != nil {
+		return err
+	}
+	if !serviceAccountsEqual(inst.ServiceAccounts, serviceAccounts) {
+		email := "none"
+		if len(inst.ServiceAccounts) > 0 {
+			email = inst.ServiceAccounts[0].Email
+		}
+		diffs = append(diffs, fmt.Sprintf("service account is %s, or its scopes differ", email))
+	}
+
+	scheduling, err := instanceScheduling(instance)
+	if err != nil {
+		return err
+	}
+	if !schedulingEqual(inst.Scheduling, scheduling) {
+		diffs = append(diffs, "scheduling differs")
+	}
+
+	runState, _, err := instanceRunState(instance, time.Now())
+	if err != nil {
+		return err
+	}
+	if current := vmRunState(inst.Status); current != "" && current != runState {
+		diffs = append(diffs, fmt.Sprintf("run state is %s", current))
+	}
+
+	// The image of a VM can not be changed without creating it again, and its boot disk can
+	// not shrink.
+	image, err := resolveImage(comp, instance.Spec.Image)
+	if err != nil {
+		return keepPermanent(err, fmt.Errorf("error resolving image of instance '%s': %s", instance.Name, err.Error()))
+	}
+	for _, d := range inst.Disks {
+		if !d.Boot {
+			continue
+		}
+		disk, err := comp.Disks.Get(project.Spec.Name, path.Base(inst.Zone), path.Base(d.Source)).Do()
+		if err != nil {
+			return fmt.Errorf("error getting boot disk of VM '%s': %s", inst.Name, err.Error())
+		}
+		if strings.TrimPrefix(disk.SourceImage, computeAPIPrefix) != strings.TrimPrefix(image, computeAPIPrefix) {
+			diff := fmt.Sprintf("image is '%s'", disk.SourceImage)
+			diffs = append(diffs, diff)
+			immutable = append(immutable, diff)
+		}
+		if spec := instance.Spec.DiskSize; spec > 0 && disk.SizeGb != spec {
+			diff := fmt.Sprintf("disk size is %d GB", disk.SizeGb)
+			diffs = append(diffs, diff)
+			if disk.SizeGb > spec {
+				immutable = append(immutable, diff)
+			}
+		}
+	}
+
+	return c.checkAdoption(&instance.ObjectMeta, "Instance", fmt.Sprintf("VM '%s'", inst.Name), inst.Labels, instance.Spec.AdoptPolicy, diffs, immutable)
+}
+
+// vmRunState returns the run state a VM with the given status is in, or an empty string
+// while it is changing.
+func vmRunState(status string) googlev1.RunState {
+	switch status {
+	case "RUNNING":
+		return googlev1.RunStateRunning
+	case "TERMINATED":
+		return googlev1.RunStateStopped
+	case "SUSPENDED":
+		return googlev1.RunStateSuspended
+	}
+	return ""
+}
+
+// adoptDatabase makes sure an existing Cloud SQL instance belongs to the database, or may be
+// adopted by it. With the policy Update, its settings are changed to match the spec. The
+// database is marked as adopted, so that the password of the instance is left alone.
+func (c *Controller) adoptDatabase(database *googlev1.Database, sqla *sqladmin.Service, project *googleProject, inst *sqladmin.DatabaseInstance, settings *databaseSettings, authNets []string, activationPolicy string) error {
+	var labels map[string]string
+	if inst.Settings != nil {
+		labels = inst.Settings.UserLabels
+	}
+	if ownsResource(&database.ObjectMeta, &database.Status.ResourceStatus, inst.ConnectionName, inst.Name, databaseExternalName(database), labels) {
+		return nil
+	}
+
+	diffs, immutable := databaseMismatches(inst, project, settings, authNets, activationPolicy)
+
+	resource := fmt.Sprintf("Cloud SQL instance '%s'", inst.Name)
+	if err := c.checkAdoption(&database.ObjectMeta, "Database", resource, labels, database.Spec.AdoptPolicy, diffs, immutable); err != nil {
+		return err
+	}
+
+	if database.Annotations == nil {
+		database.Annotations = map[string]string{}
+	}
+	database.Annotations[adoptedAnnotation] = "true"
+	addFinalizer(&database.ObjectMeta)
+	if len(diffs) == 0 {
+		return c.updateDatabase(database)
+	}
+
+	desired := settings.instance(inst.Name).Settings
+	patch := &sqladmin.Settings{
+		Tier:              desired.Tier,
+		DataDiskType:      desired.DataDiskType,
+		StorageAutoResize: desired.StorageAutoResize,
+		AvailabilityType:  desired.AvailabilityType,
+		ActivationPolicy:  activationPolicy,
+		IpConfiguration: &sqladmin.IpConfiguration{
+			AuthorizedNetworks: aclEntries(authNets),
+			ForceSendFields:    []string{"AuthorizedNetworks"},
+		},
+	}
+	// Disks can only grow.
+	if inst.Settings == nil || inst.Settings.DataDiskSizeGb < desired.DataDiskSizeGb {
+		patch.DataDiskSizeGb = desired.DataDiskSizeGb
+	}
+
+	op, err := sqla.Instances.Patch(project.Spec.Name, inst.Name, &sqladmin.DatabaseInstance{Settings: patch}).Do()
+	if err != nil {
+		return keepPermanent(err, c.MakeEventAndFail(&database.ObjectMeta, "Database", fmt.Sprintf("could not update %s to match the spec: %s", resource, err.Error())))
+	}
+
+	// Recording the ID marks the instance as adopted, the labels are added afterwards.
+	database.Status.ID = inst.ConnectionName
+	database.Status.ExternalName = inst.Name
+	setPendingOperation(&database.ObjectMeta, op.Name)
+	if err := c.updateDatabase(database); err != nil {
+		return err
+	}
+
+	c.MakeEvent(&database.ObjectMeta, "Database", fmt.Sprintf("updating %s to match the spec", resource), false)
+	return RequeueAfter(operationPollInterval, "waiting for update of database '%s'", database.Name)
+}
+
+// databaseMismatches lists how a Cloud SQL instance differs from the settings, authorized
+// networks and activation policy of a database, and which of the differences can not be
+// changed. Without a schedule, the activation policy is not compared.
+func databaseMismatches(inst *sqladmin.DatabaseInstance, project *googleProject, settings *databaseSettings, authNets []string, activationPolicy string) (diffs, immutable []string) {
+	if inst.DatabaseVersion != settings.DatabaseVersion {
+		immutable = append(immutable, fmt.Sprintf("version is %s", inst.DatabaseVersion))
+	}
+	if inst.Region != project.Spec.Region {
+		immutable = append(immutable, fmt.Sprintf("region is %s", inst.Region))
+	}
+	diffs = append(diffs, immutable...)
+
+	if s := inst.Settings; s != nil {
+		if s.Tier != settings.Tier {
+			diffs = append(diffs, fmt.Sprintf("tier is %s", s.Tier))
+		}
+		if s.DataDiskType != settings.StorageType {
+			diffs = append(diffs, fmt.Sprintf("storage type is %s", s.DataDiskType))
+		}
+		if s.DataDiskSizeGb < settings.DiskSize {
+			diffs = append(diffs, fmt.Sprintf("disk size is %d GB", s.DataDiskSizeGb))
+		}
+		if s.AvailabilityType != "" && settings.AvailabilityType != "" && s.AvailabilityType != settings.AvailabilityType {
+			diffs = append(diffs, fmt.Sprintf("availability type is %s", s.AvailabilityType))
+		}
+		if activationPolicy != "" && s.ActivationPolicy != activationPolicy {
+			diffs = append(diffs, fmt.Sprintf("activation policy is %s", s.ActivationPolicy))
+		}
+	}
+
+	if current := currentAuthorizedNetworks(inst); !stringsEqual(current, authNets) {
+		diffs = append(diffs, fmt.Sprintf("authorized networks are [%s]", strings.Join(current, ", ")))
+	}
+
+	return diffs, immutable
+}
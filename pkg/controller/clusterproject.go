@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+func (c *Controller) ClusterProjectCreatedOrUpdated(project *googlev1.ClusterProject) error {
+	log.Debugf("processing created or updated clusterproject '%s'", project.Name)
+
+	project = project.DeepCopy()
+	status := project.Status.DeepCopy()
+
+	if project.DeletionTimestamp != nil {
+		return c.handleError(c.ClusterProjectQueue, &project.ObjectMeta, "ClusterProject", c.finalizeProject(c.clusterGoogleProject(project), &project.ObjectMeta, func() error {
+			return c.updateClusterProject(project)
+		}))
+	}
+
+	if !hasFinalizer(&project.ObjectMeta) {
+		addFinalizer(&project.ObjectMeta)
+		if err := c.updateClusterProject(project); err != nil {
+			return c.handleError(c.ClusterProjectQueue, &project.ObjectMeta, "ClusterProject", err)
+		}
+	}
+
+	err := c.verifyProject(c.clusterGoogleProject(project), &project.ObjectMeta, &project.Status)
+	if err == nil {
+		err = validateAllowedNamespaces(project.Spec.AllowedNamespaces)
+		if err != nil {
+			setReady(&project.Status.ResourceStatus, false, "InvalidSpec", err.Error())
+		}
+	}
+
+	setSynced(&project.Status.ResourceStatus, project.Generation, err)
+	if !equality.Semantic.DeepEqual(status, &project.Status) {
+		if _, serr := c.GoogleClient.GoogleV1().ClusterProjects().UpdateStatus(project); serr != nil {
+			log.Errorf("error updating status of clusterproject '%s': %s", project.Name, serr.Error())
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+
+	return c.handleError(c.ClusterProjectQueue, &project.ObjectMeta, "ClusterProject", err)
+}
+
+func (c *Controller) ClusterProjectDeleted(project *googlev1.ClusterProject) error {
+	log.Debugf("processing deleted clusterproject '%s'", project.Name)
+	return nil
+}
+
+// updateClusterProject writes the metadata and spec of the clusterproject and refreshes the
+// object in place. The status is kept, it is written separately.
+func (c *Controller) updateClusterProject(project *googlev1.ClusterProject) error {
+	updated, err := c.GoogleClient.GoogleV1().ClusterProjects().Update(project)
+	if err != nil {
+		return fmt.Errorf("error updating clusterproject '%s': %s", project.Name, err.Error())
+	}
+
+	status := project.Status
+	*project = *updated
+	project.Status = status
+	return nil
+}
+
+func (c *Controller) clusterGoogleProject(project *googlev1.ClusterProject) *googleProject {
+	return &googleProject{
+		Kind:                 "ClusterProject",
+		Name:                 project.Name,
+		Spec:                 &project.Spec.ProjectSpec,
+		credentialsNamespace: c.CredentialsNamespace,
+	}
+}
+
+func validateAllowedNamespaces(allowed *googlev1.AllowedNamespaces) error {
+	if allowed == nil || allowed.Selector == nil {
+		return nil
+	}
+	if _, err := metav1.LabelSelectorAsSelector(allowed.Selector); err != nil {
+		return NewPermanentError("invalid allowedNamespaces.selector: %s", err.Error())
+	}
+	return nil
+}
+
+// namespaceAllowed reports whether objects in namespace may use the ClusterProject.
+func (c *Controller) namespaceAllowed(project *googlev1.ClusterProject, namespace string) (bool, error) {
+	allowed := project.Spec.AllowedNamespaces
+	if allowed == nil {
+		return false, nil
+	}
+
+	for _, name := range allowed.Names {
+		if name == namespace {
+			return true, nil
+		}
+	}
+
+	if allowed.Selector == nil {
+		return false, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(allowed.Selector)
+	if err != nil {
+		return false, NewPermanentError("clusterproject '%s' has an invalid allowedNamespaces.selector: %s", project.Name, err.Error())
+	}
+
+	ns, err := c.NamespaceLister.Get(namespace)
+	if err != nil {
+		return false, fmt.Errorf("error getting namespace '%s': %s", namespace, err.Error())
+	}
+
+	return selector.Matches(labels.Set(ns.Labels)), nil
+}
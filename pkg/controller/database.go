@@ -7,93 +7,260 @@ import (
 googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
 
 	"fmt"
+	"strings"
+	"time"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/sqladmin/v1beta4"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func (c *Controller) DatabaseCreatedOrUpdated(database *googlev1.Database) error {
 	log.Debugf("processing created or updated database '%s/%s'", database.Namespace, database.Name)
 
-	var projectName string
-	if projectName = database.Spec.Project; projectName == "" {
-		projectName = "default"
+	if database.DeletionTimestamp != nil {
+		return c.handleError(c.DatabaseQueue, &database.ObjectMeta, "Database", c.DatabaseDeleted(database))
 	}
 
-	project, err := c.ProjectLister.Projects(database.Namespace).Get(projectName)
+	database = database.DeepCopy()
+	status := database.Status.DeepCopy()
+
+	deadline, err := expiryDeadline(&database.ObjectMeta, database.Spec.Expiry)
+	if err == nil {
+		database.Status.ExpiresAt = optionalTime(deadline)
+
+		var expired bool
+		expired, err = c.checkExpiry(&database.ObjectMeta, "Database", deadline, &database.Status.ResourceStatus, func() error {
+			return c.GoogleClient.GoogleV1().Databases(database.Namespace).Delete(database.Name, &metav1.DeleteOptions{})
+		})
+		if expired {
+			// The object is being deleted, its status would only conflict.
+			return nil
+		}
+	}
+	if err == nil {
+		err = c.reconcileDatabase(database)
+		err = c.requeueForExpiry(err, "database", database.Name, deadline)
+	}
+
+	setSynced(&database.Status.ResourceStatus, database.Generation, err)
+	if !equality.Semantic.DeepEqual(status, &database.Status) {
+		if _, serr := c.GoogleClient.GoogleV1().Databases(database.Namespace).UpdateStatus(database); serr != nil {
+			log.Errorf("error updating status of database '%s/%s': %s", database.Namespace, database.Name, serr.Error())
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+
+	return c.handleError(c.DatabaseQueue, &database.ObjectMeta, "Database", err)
+}
+
+func (c *Controller) reconcileDatabase(database *googlev1.Database) error {
+	settings, err := databaseSpecSettings(&database.Spec)
 	if err != nil {
-		return fmt.Errorf("error getting project '%s-%s': %s", database.Namespace, projectName, err.Error())
+		message := fmt.Sprintf("database '%s' has an invalid spec: %s", database.Name, err.Error())
+		_ = c.MakeEvent(&database.ObjectMeta, "Database", message, true)
+		setReady(&database.Status.ResourceStatus, false, "InvalidSpec", message)
+		return NewPermanentError("%s", message)
 	}
 
-	sqla, err := c.SqladminService(projectName, database.Namespace)
+	if err := c.checkExternalName(&database.ObjectMeta, "Database", database.Spec.ExternalName, database.Status.ExternalName); err != nil {
+		setReady(&database.Status.ResourceStatus, false, "InvalidSpec", err.Error())
+		return err
+	}
+
+	activationPolicy, next, err := databaseActivationPolicy(database, time.Now())
 	if err != nil {
+		_ = c.MakeEvent(&database.ObjectMeta, "Database", err.Error(), true)
+		setReady(&database.Status.ResourceStatus, false, "InvalidSpec", err.Error())
 		return err
 	}
 
-	comp, err := c.ComputeService(projectName, database.Namespace)
+	// Once the database exists, it stays in the default project it was created in.
+	projectName := database.Spec.Project
+	if projectName == "" && database.Status.ID != "" {
+		projectName = database.Status.Project
+	}
+
+	project, err := c.resolveProject(&database.ObjectMeta, "Database", projectName)
 	if err != nil {
 		return err
 	}
+	database.Status.Project = project.Name
 
-	computeInstances, err := comp.Instances.List(project.Spec.Name, project.Spec.Zone).Do()
+	sqla, err := c.SqladminService(project)
 	if err != nil {
 		return err
 	}
 
-	authNets := make([]*sqladmin.AclEntry, len(computeInstances.Items))
-	for i, in := range computeInstances.Items {
-		for _, iface := range in.NetworkInterfaces {
-			for _, ac := range iface.AccessConfigs {
-				authNets[i] = &sqladmin.AclEntry{Value: ac.NatIP}
-			}
-		}
+	authNets, err := c.desiredAuthorizedNetworks(database)
+	if err != nil {
+		return err
+	}
+
+	labels, err := managedLabels(&database.ObjectMeta, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := c.waitForDatabaseOperation(database, sqla, project); err != nil {
+		return err
 	}
 
 	notfound := false
-	inst, err := sqla.Instances.Get(project.Spec.Name, database.Name).Do()
+	inst, err := sqla.Instances.Get(project.Spec.Name, databaseExternalName(database)).Do()
 	if err != nil {
-		if gerr, ok := err.(*googleapi.Error); ok {
-			// TODO: better way to handle NotFound?
-			if gerr.Code == 404 {
-				notfound = true
-			} else if gerr.Code == 403 {
-				notfound = true // we do not get 404 when the database does not exist for some reason
-			} else {
-				return fmt.Errorf("error getting database '%s': %s", database.Name, gerr.Error())
-			}
-		} else {
+		gone, lerr := databaseGone(sqla, project.Spec.Name, databaseExternalName(database), err)
+		if lerr != nil {
+			return lerr
+		}
+		if !gone {
 			return fmt.Errorf("error getting database '%s': %s", database.Name, err.Error())
 		}
+		notfound = true
+	}
+
+	if !notfound {
+		if err := c.adoptDatabase(database, sqla, project, inst, settings, authNets, activationPolicy); err != nil {
+			if _, requeue := err.(*RequeueError); !requeue {
+				setReady(&database.Status.ResourceStatus, false, "NotAdopted", err.Error())
+			}
+			return err
+		}
+	}
+
+	// Added only once the Cloud SQL instance is owned or about to be created, as the
+	// finalizer marks instances created before the labels were introduced as owned.
+	if !hasFinalizer(&database.ObjectMeta) {
+		addFinalizer(&database.ObjectMeta)
+		if err := c.updateDatabase(database); err != nil {
+			return err
+		}
 	}
 
 	if notfound {
 		log.Debugf("database '%s' not found", database.Name)
 
-		db := sqladmin.DatabaseInstance{
-			Name: database.Name,
-			BackendType: "SECOND_GEN",
-			DatabaseVersion: "MYSQL_5_7",
-			Settings: &sqladmin.Settings{
-				Tier: "db-n1-standard-1",
-				IpConfiguration: &sqladmin.IpConfiguration{
-					AuthorizedNetworks: authNets,
-				},
-			},
+		db := settings.instance(databaseExternalName(database))
+		db.Region = project.Spec.Region
+		db.Settings.ActivationPolicy = activationPolicy
+		db.Settings.DeletionProtectionEnabled = database.Spec.DeletionProtection
+		db.Settings.UserLabels = labels
+		db.Settings.IpConfiguration = &sqladmin.IpConfiguration{
+			AuthorizedNetworks: aclEntries(authNets),
 		}
 
-		_, err := sqla.Instances.Insert(project.Spec.Name, &db).Do()
+		op, err := sqla.Instances.Insert(project.Spec.Name, db).Do()
 		if err != nil {
-			return c.MakeEventAndFail(&database.ObjectMeta, "database", fmt.Sprintf("could not create database '%s': %s", database.Name, err.Error()))
-		} else {
-			c.MakeEvent(&database.ObjectMeta, "database", fmt.Sprintf("requested provisioning of database '%s'", database.Name), false)
-			return nil
+			return keepPermanent(err, c.MakeEventAndFail(&database.ObjectMeta, "database", fmt.Sprintf("could not create database '%s': %s", database.Name, err.Error())))
 		}
 
-		// TODO: create password and store in Secret
-		// TODO: create ConfigMap and/or Service with database address
+		setPendingOperation(&database.ObjectMeta, op.Name)
+		if err := c.updateDatabase(database); err != nil {
+			return err
+		}
+
+		database.Status.ExternalName = db.Name
+		c.MakeEvent(&database.ObjectMeta, "database", fmt.Sprintf("requested provisioning of database '%s'", database.Name), false)
+		setReady(&database.Status.ResourceStatus, false, "Creating", "requested provisioning of database")
+		return RequeueAfter(operationPollInterval, "waiting for provisioning of database '%s'", database.Name)
 
 	} else {
 		log.Debugf("database '%s' found", inst.Name)
 		// TODO: Check status of database. Any values that could be changed for a running database?
+
+		database.Status.SelfLink = inst.SelfLink
+		database.Status.ID = inst.ConnectionName
+		database.Status.ExternalName = inst.Name
+
+		switch inst.State {
+		case "RUNNABLE":
+			if current := inst.Settings.ActivationPolicy; activationPolicy != "" && current != activationPolicy {
+				op, err := sqla.Instances.Patch(project.Spec.Name, databaseExternalName(database), &sqladmin.DatabaseInstance{
+					Settings: &sqladmin.Settings{
+						ActivationPolicy: activationPolicy,
+					},
+				}).Do()
+				if err != nil {
+					return keepPermanent(err, c.MakeEventAndFail(&database.ObjectMeta, "Database", fmt.Sprintf("could not change the activation policy of database '%s': %s", database.Name, err.Error())))
+				}
+
+				setPendingOperation(&database.ObjectMeta, op.Name)
+				if err := c.updateDatabase(database); err != nil {
+					return err
+				}
+
+				action := "starting"
+				if activationPolicy == "NEVER" {
+					action = "stopping"
+				}
+				c.MakeEvent(&database.ObjectMeta, "Database", scheduledMessage(fmt.Sprintf("%s database '%s'", action, database.Name), database.Spec.Schedule), false)
+				setReady(&database.Status.ResourceStatus, false, "Changing", fmt.Sprintf("%s database", action))
+				return RequeueAfter(operationPollInterval, "waiting for %s of database '%s'", action, database.Name)
+			}
+
+			if current := currentAuthorizedNetworks(inst); !stringsEqual(current, authNets) {
+				op, err := sqla.Instances.Patch(project.Spec.Name, databaseExternalName(database), &sqladmin.DatabaseInstance{
+					Settings: &sqladmin.Settings{
+						IpConfiguration: &sqladmin.IpConfiguration{
+							AuthorizedNetworks: aclEntries(authNets),
+							ForceSendFields:    []string{"AuthorizedNetworks"},
+						},
+					},
+				}).Do()
+				if err != nil {
+					return keepPermanent(err, c.MakeEventAndFail(&database.ObjectMeta, "Database", fmt.Sprintf("could not update authorized networks of database '%s': %s", database.Name, err.Error())))
+				}
+
+				setPendingOperation(&database.ObjectMeta, op.Name)
+				if err := c.updateDatabase(database); err != nil {
+					return err
+				}
+
+				c.MakeEvent(&database.ObjectMeta, "Database", fmt.Sprintf("updating authorized networks of database '%s' to [%s]", database.Name, strings.Join(authNets, ", ")), false)
+				return RequeueAfter(operationPollInterval, "waiting for update of authorized networks of database '%s'", database.Name)
+			}
+
+			if inst.Settings.DeletionProtectionEnabled != database.Spec.DeletionProtection || !hasManagementLabels(&database.ObjectMeta, inst.Settings.UserLabels) {
+				op, err := sqla.Instances.Patch(project.Spec.Name, databaseExternalName(database), &sqladmin.DatabaseInstance{
+					Settings: &sqladmin.Settings{
+						DeletionProtectionEnabled: database.Spec.DeletionProtection,
+						UserLabels:                labels,
+						ForceSendFields:           []string{"DeletionProtectionEnabled"},
+					},
+				}).Do()
+				if err != nil {
+					return keepPermanent(err, c.MakeEventAndFail(&database.ObjectMeta, "Database", fmt.Sprintf("could not update deletion protection and labels of database '%s': %s", database.Name, err.Error())))
+				}
+
+				setPendingOperation(&database.ObjectMeta, op.Name)
+				if err := c.updateDatabase(database); err != nil {
+					return err
+				}
+
+				c.MakeEvent(&database.ObjectMeta, "Database", fmt.Sprintf("setting deletion protection of database '%s' to %t", database.Name, database.Spec.DeletionProtection), false)
+				return RequeueAfter(operationPollInterval, "waiting for update of database '%s'", database.Name)
+			}
+
+			// A stopped database can not be connected to, the secret is left as it is.
+			if activationPolicy == "NEVER" {
+				setReady(&database.Status.ResourceStatus, true, "Stopped", "")
+				return requeueForSchedule("database", database.Name, next)
+			}
+
+			if err := c.ensureConnectionSecret(database, sqla, project, inst); err != nil {
+				setReady(&database.Status.ResourceStatus, false, "ConnectionSecretFailed", err.Error())
+				return err
+			}
+			setReady(&database.Status.ResourceStatus, true, "Runnable", "")
+			return requeueForSchedule("database", database.Name, next)
+		case "PENDING_CREATE":
+			setReady(&database.Status.ResourceStatus, false, "Creating", fmt.Sprintf("database is %s", inst.State))
+			return RequeueAfter(operationPollInterval, "waiting for provisioning of database '%s'", database.Name)
+		default:
+			setReady(&database.Status.ResourceStatus, false, "NotRunnable", fmt.Sprintf("database is %s", inst.State))
+		}
 	}
 
 	return nil
@@ -101,31 +268,252 @@ func (c *Controller) DatabaseCreatedOrUpdated(database *googlev1.Database) error
 
 func (c *Controller) DatabaseDeleted(database *googlev1.Database) error {
 	log.Debugf("processing deleted database '%s/%s'", database.Namespace, database.Name)
-	// TODO: Use a finalizer.
 
-	var projectName string
-	if projectName = database.Spec.Project; projectName == "" {
-		projectName = "default"
+	if !hasFinalizer(&database.ObjectMeta) {
+		return nil
+	}
+
+	database = database.DeepCopy()
+
+	// A Cloud SQL instance that is kept does not need the project, which may be gone already,
+	// e.g. while the namespace is deleted.
+	if database.Spec.DeletionPolicy == googlev1.DeletionPolicyRetain {
+		return c.releaseDatabase(database, nil, nil, nil, googlev1.DeletionPolicyRetain)
+	}
+
+	// Use the project the database was created in, even if the default has changed since.
+	projectName := database.Status.Project
+	if projectName == "" {
+		projectName = database.Spec.Project
+	}
+
+	project, err := c.resolveProject(&database.ObjectMeta, "Database", projectName)
+	var sqla *sqladmin.Service
+	if err == nil {
+		sqla, err = c.SqladminService(project)
+	}
+	if err != nil {
+		if database.Spec.DeletionPolicy == googlev1.DeletionPolicyOrphan {
+			_ = c.MakeEvent(&database.ObjectMeta, "Database", fmt.Sprintf("could not remove the management labels from the Cloud SQL instance of database '%s': %s", database.Name, err.Error()), true)
+			return c.releaseDatabase(database, nil, nil, nil, googlev1.DeletionPolicyOrphan)
+		}
+		return err
+	}
+
+	if opName := pendingOperation(&database.ObjectMeta); opName != "" {
+		op, err := sqla.Operations.Get(project.Spec.Name, opName).Do()
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("error getting operation '%s' for database '%s': %s", opName, database.Name, err.Error())
+		}
+
+		var opError string
+		if err == nil {
+			if op.Status != "DONE" {
+				return RequeueAfter(operationPollInterval, "waiting for deletion of database '%s'", database.Name)
+			}
+			opError = sqladminOperationError(op)
+		}
+
+		setPendingOperation(&database.ObjectMeta, "")
+		if err := c.updateDatabase(database); err != nil {
+			return err
+		}
+
+		if opError != "" {
+			return c.MakeEventAndFail(&database.ObjectMeta, "database", fmt.Sprintf("deletion of database '%s' failed: %s", database.Name, opError))
+		}
 	}
 
-	project, err := c.ProjectLister.Projects(database.Namespace).Get(projectName)
+	inst, err := sqla.Instances.Get(project.Spec.Name, databaseExternalName(database)).Do()
 	if err != nil {
-		return fmt.Errorf("error getting project '%s-%s': %s", database.Namespace, projectName, err.Error())
+		gone, lerr := databaseGone(sqla, project.Spec.Name, databaseExternalName(database), err)
+		if lerr != nil {
+			return lerr
+		}
+		if !gone {
+			return fmt.Errorf("error getting database '%s': %s", database.Name, err.Error())
+		}
+
+		removeFinalizer(&database.ObjectMeta)
+		if err := c.updateDatabase(database); err != nil {
+			return err
+		}
+		c.MakeEvent(&database.ObjectMeta, "database", fmt.Sprintf("database '%s' is deleted", database.Name), false)
+		return nil
 	}
 
-	sqla, err := c.SqladminService(projectName, database.Namespace)
+	policy, err := deletionPolicy(database.Spec.DeletionPolicy, project)
 	if err != nil {
 		return err
 	}
 
-	_, err = sqla.Instances.Delete(project.Spec.Name, database.Name).Do()
+	// A Cloud SQL instance the database never adopted is not touched.
+	var labels map[string]string
+	if inst.Settings != nil {
+		labels = inst.Settings.UserLabels
+	}
+	if !ownsResource(&database.ObjectMeta, &database.Status.ResourceStatus, inst.ConnectionName, inst.Name, databaseExternalName(database), labels) {
+		if policy == googlev1.DeletionPolicyDelete {
+			_ = c.MakeEvent(&database.ObjectMeta, "Database", fmt.Sprintf("Cloud SQL instance '%s' does not belong to database '%s' and is kept instead of deleted", inst.Name, database.Name), true)
+		}
+		policy = googlev1.DeletionPolicyRetain
+	}
+
+	if policy != googlev1.DeletionPolicyDelete {
+		return c.releaseDatabase(database, sqla, project, inst, policy)
+	}
+
+	if inst.Settings != nil && inst.Settings.DeletionProtectionEnabled {
+		message := fmt.Sprintf("database '%s' has deletion protection, disable it or set the deletionPolicy to Retain", database.Name)
+		_ = c.MakeEvent(&database.ObjectMeta, "Database", message, true)
+		return NewPermanentError("%s", message)
+	}
+
+	op, err := sqla.Instances.Delete(project.Spec.Name, databaseExternalName(database)).Do()
 	if err != nil {
-		return c.MakeEventAndFail(&database.ObjectMeta, "database", fmt.Sprintf("could not delete database '%s': %s", database.Name, err.Error()))
-	} else {
-		c.MakeEvent(&database.ObjectMeta, "database", fmt.Sprintf("requested deletion of database '%s'", database.Name), false)
+		return keepPermanent(err, c.MakeEventAndFail(&database.ObjectMeta, "database", fmt.Sprintf("could not delete database '%s': %s", database.Name, err.Error())))
+	}
+
+	setPendingOperation(&database.ObjectMeta, op.Name)
+	if err := c.updateDatabase(database); err != nil {
+		return err
+	}
+
+	c.MakeEvent(&database.ObjectMeta, "database", fmt.Sprintf("requested deletion of database '%s'", database.Name), false)
+	return RequeueAfter(operationPollInterval, "waiting for deletion of database '%s'", database.Name)
+}
+
+// releaseDatabase lets go of the Cloud SQL instance of a deleted database without deleting
+// it. With the deletion policy Orphan, its management labels are removed first, unless inst
+// is nil because the instance could not be looked at.
+func (c *Controller) releaseDatabase(database *googlev1.Database, sqla *sqladmin.Service, project *googleProject, inst *sqladmin.DatabaseInstance, policy googlev1.DeletionPolicy) error {
+	if policy == googlev1.DeletionPolicyOrphan && inst != nil && inst.Settings != nil {
+		var remove []string
+		for k := range inst.Settings.UserLabels {
+			if strings.HasPrefix(k, managementLabelPrefix) {
+				remove = append(remove, "UserLabels."+k)
+			}
+		}
+
+		if len(remove) > 0 {
+			_, err := sqla.Instances.Patch(project.Spec.Name, databaseExternalName(database), &sqladmin.DatabaseInstance{
+				Settings: &sqladmin.Settings{
+					NullFields: remove,
+				},
+			}).Do()
+			if err != nil {
+				return keepPermanent(err, fmt.Errorf("error removing labels of database '%s': %s", database.Name, err.Error()))
+			}
+		}
+	}
+
+	removeFinalizer(&database.ObjectMeta)
+	if err := c.updateDatabase(database); err != nil {
+		return err
+	}
+
+	c.MakeEvent(&database.ObjectMeta, "Database", fmt.Sprintf("database '%s' is deleted, the Cloud SQL instance is kept because of the deletion policy %s", database.Name, policy), false)
+	return nil
+}
+
+// waitForDatabaseOperation checks on the operation the database is waiting for, if there is
+// one. While it is running, a RequeueError is returned. Once it is done, it is removed from
+// the database, and if it failed, a Warning event is emitted and an error returned.
+func (c *Controller) waitForDatabaseOperation(database *googlev1.Database, sqla *sqladmin.Service, project *googleProject) error {
+	opName := pendingOperation(&database.ObjectMeta)
+	if opName == "" {
 		return nil
 	}
 
+	op, err := sqla.Operations.Get(project.Spec.Name, opName).Do()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("error getting operation '%s' for database '%s': %s", opName, database.Name, err.Error())
+	}
+
+	var opType, opError string
+	if err == nil {
+		if op.Status != "DONE" {
+			return RequeueAfter(operationPollInterval, "waiting for %s of database '%s'", op.OperationType, database.Name)
+		}
+		opType = op.OperationType
+		opError = sqladminOperationError(op)
+	}
+
+	setPendingOperation(&database.ObjectMeta, "")
+	if err := c.updateDatabase(database); err != nil {
+		return err
+	}
+
+	if opError != "" {
+		return c.MakeEventAndFail(&database.ObjectMeta, "Database", fmt.Sprintf("%s of database '%s' failed: %s", opType, database.Name, opError))
+	}
+
+	return nil
+}
+
+// updateDatabase writes the metadata and spec of the database and refreshes the object in
+// place. The status is kept, it is written separately.
+func (c *Controller) updateDatabase(database *googlev1.Database) error {
+	updated, err := c.GoogleClient.GoogleV1().Databases(database.Namespace).Update(database)
+	if err != nil {
+		return fmt.Errorf("error updating database '%s/%s': %s", database.Namespace, database.Name, err.Error())
+	}
+
+	status := database.Status
+	*database = *updated
+	database.Status = status
 	return nil
 }
 
+// databaseActivationPolicy returns the activation policy the schedule of a database asks for
+// now, ALWAYS or NEVER, and when it changes next. Without a schedule, the activation policy is
+// left alone and an empty string is returned.
+func databaseActivationPolicy(database *googlev1.Database, now time.Time) (string, time.Time, error) {
+	if database.Spec.Schedule == nil {
+		return "", time.Time{}, nil
+	}
+
+	running, next, err := evaluateSchedule(database.Spec.Schedule, now)
+	if err != nil {
+		return "", time.Time{}, keepPermanent(err, fmt.Errorf("error in schedule of database '%s': %s", database.Name, err.Error()))
+	}
+	if !running {
+		return "NEVER", next, nil
+	}
+	return "ALWAYS", next, nil
+}
+
+// databaseGone reports whether err, returned by Instances.Get, means that the Cloud SQL
+// instance does not exist. A missing instance gives a 403 rather than a 404, but so does one
+// we may not access, so a 403 is only trusted if Instances.List does not return the instance
+// either.
+func databaseGone(sqla *sqladmin.Service, project, name string, err error) (bool, error) {
+	gerr, ok := err.(*googleapi.Error)
+	if !ok || (gerr.Code != 404 && gerr.Code != 403) {
+		return false, nil
+	}
+	if gerr.Code == 404 {
+		return true, nil
+	}
+
+	pageToken := ""
+	for {
+		call := sqla.Instances.List(project)
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		list, err := call.Do()
+		if err != nil {
+			return false, fmt.Errorf("error listing Cloud SQL instances to check whether '%s' is gone: %s", name, err.Error())
+		}
+		for _, inst := range list.Items {
+			if inst.Name == name {
+				return false, nil
+			}
+		}
+		if list.NextPageToken == "" {
+			return true, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
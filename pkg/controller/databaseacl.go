@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"google.golang.org/api/sqladmin/v1beta4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// desiredAuthorizedNetworks returns the networks that may connect to a database, the
+// networks from its spec and the external IPs of the Instances matched by its
+// instanceSelector. The result is sorted and has no duplicates.
+func (c *Controller) desiredAuthorizedNetworks(database *googlev1.Database) ([]string, error) {
+	networks := append([]string{}, database.Spec.AuthorizedNetworks...)
+
+	if database.Spec.InstanceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(database.Spec.InstanceSelector)
+		if err != nil {
+			return nil, NewPermanentError("invalid instanceSelector: %s", err.Error())
+		}
+
+		instances, err := c.InstanceLister.Instances(database.Namespace).List(selector)
+		if err != nil {
+			return nil, fmt.Errorf("error listing instances in namespace '%s': %s", database.Namespace, err.Error())
+		}
+
+		for _, instance := range instances {
+			networks = append(networks, instance.Status.ExternalIPs...)
+		}
+	}
+
+	return uniqueSorted(networks), nil
+}
+
+// validateAuthorizedNetworks checks that all networks are given in CIDR notation, or as a
+// single IP address.
+func validateAuthorizedNetworks(networks []string) error {
+	for _, n := range networks {
+		if _, _, err := net.ParseCIDR(n); err == nil {
+			continue
+		}
+		if net.ParseIP(n) == nil {
+			return NewPermanentError("invalid authorized network '%s', must be in CIDR notation", n)
+		}
+	}
+	return nil
+}
+
+// currentAuthorizedNetworks returns the networks that may connect to a Cloud SQL instance,
+// sorted and without duplicates.
+func currentAuthorizedNetworks(inst *sqladmin.DatabaseInstance) []string {
+	var networks []string
+	if inst.Settings != nil && inst.Settings.IpConfiguration != nil {
+		for _, acl := range inst.Settings.IpConfiguration.AuthorizedNetworks {
+			if acl != nil {
+				networks = append(networks, acl.Value)
+			}
+		}
+	}
+	return uniqueSorted(networks)
+}
+
+func aclEntries(networks []string) []*sqladmin.AclEntry {
+	entries := make([]*sqladmin.AclEntry, 0, len(networks))
+	for _, n := range networks {
+		entries = append(entries, &sqladmin.AclEntry{Value: n})
+	}
+	return entries
+}
+
+func uniqueSorted(list []string) []string {
+	sort.Strings(list)
+	var result []string
+	for i, s := range list {
+		if i == 0 || s != list[i-1] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
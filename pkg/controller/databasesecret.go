@@ -0,0 +1,267 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sqladmin/v1beta4"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// The keys of the connection Secret of a database.
+const (
+	connectionHost           = "host"
+	connectionPort           = "port"
+	connectionConnectionName = "connectionName"
+	connectionUsername       = "username"
+	connectionPassword       = "password"
+	connectionDSN            = "dsn"
+)
+
+// How often to check for the connection Secret of an adopted database while it does not
+// exist yet.
+const connectionSecretPollInterval = time.Minute
+
+// passwordPendingAnnotation marks a connection Secret whose password is not set on the
+// database yet.
+const passwordPendingAnnotation = "google.cloudcrd.weisnix.org/password-pending"
+
+const (
+	passwordLength  = 32
+	passwordLetters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+func connectionSecretName(database *googlev1.Database) string {
+	if database.Spec.ConnectionSecret != "" {
+		return database.Spec.ConnectionSecret
+	}
+	return database.Name + "-connection"
+}
+
+func generatePassword() (string, error) {
+	max := big.NewInt(int64(len(passwordLetters)))
+	b := make([]byte, passwordLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordLetters[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// databaseUser returns the name and host of the user the controller manages, the built-in
+// administrative user of the engine.
+func databaseUser(inst *sqladmin.DatabaseInstance) (name string, host string) {
+	if strings.HasPrefix(inst.DatabaseVersion, "POSTGRES") {
+		return "postgres", ""
+	}
+	return "root", "%"
+}
+
+// databaseEndpoint returns the address and port apps connect to, and a DSN for them.
+func databaseEndpoint(inst *sqladmin.DatabaseInstance, username, password string) (host string, port int, dsn string) {
+	for _, ip := range inst.IpAddresses {
+		if ip.Type == "PRIMARY" || ip.Type == "" {
+			host = ip.IpAddress
+			break
+		}
+	}
+
+	scheme := "mysql"
+	port = 3306
+	if strings.HasPrefix(inst.DatabaseVersion, "POSTGRES") {
+		scheme = "postgres"
+		port = 5432
+	}
+
+	u := url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(username, password),
+		Host:   host + ":" + strconv.Itoa(port),
+		Path:   "/",
+	}
+
+	return host, port, u.String()
+}
+
+// ensureConnectionSecret writes the Secret apps use to connect to a running database. The
+// password of the user is generated and set the first time, afterwards it is read back from
+// the Secret. A new password is saved in the Secret before it is set, marked as pending
+// until it is, so that it is neither lost nor left unset if setting it fails.
+func (c *Controller) ensureConnectionSecret(database *googlev1.Database, sqla *sqladmin.Service, project *googleProject, inst *sqladmin.DatabaseInstance) error {
+	name := connectionSecretName(database)
+
+	secret, err := c.SecretLister.Secrets(database.Namespace).Get(name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("error getting secret '%s/%s': %s", database.Namespace, name, err.Error())
+		}
+		secret = nil
+	}
+
+	if database.Annotations[adoptedAnnotation] == "true" {
+		return c.completeConnectionSecret(database, secret, name, inst)
+	}
+
+	if secret != nil && !metav1.IsControlledBy(secret, database) {
+		message := fmt.Sprintf("secret '%s' already exists and does not belong to database '%s'", name, database.Name)
+		_ = c.MakeEvent(&database.ObjectMeta, "Database", message, true)
+		return NewPermanentError("%s", message)
+	}
+
+	username, userHost := databaseUser(inst)
+
+	var password string
+	var pending bool
+	if secret != nil {
+		password = string(secret.Data[connectionPassword])
+		pending = secret.Annotations[passwordPendingAnnotation] == "true"
+	}
+
+	if password == "" {
+		password, err = generatePassword()
+		if err != nil {
+			return fmt.Errorf("error generating password for database '%s': %s", database.Name, err.Error())
+		}
+		pending = true
+	}
+
+	secret, err = c.writeConnectionSecret(database, secret, name, connectionData(inst, username, password), pending)
+	if err != nil {
+		return err
+	}
+
+	if pending {
+		_, err = sqla.Users.Update(project.Spec.Name, inst.Name, userHost, username, &sqladmin.User{
+			Name:     username,
+			Host:     userHost,
+			Password: password,
+		}).Do()
+		if err != nil {
+			return keepPermanent(err, fmt.Errorf("error setting password of user '%s' on database '%s': %s", username, database.Name, err.Error()))
+		}
+
+		c.MakeEvent(&database.ObjectMeta, "Database", fmt.Sprintf("set a new password for user '%s' of database '%s'", username, database.Name), false)
+
+		if _, err := c.writeConnectionSecret(database, secret, name, secret.Data, false); err != nil {
+			return err
+		}
+	}
+
+	database.Status.ConnectionSecret = name
+	return nil
+}
+
+// completeConnectionSecret adds the address of an adopted database to its connection Secret.
+// The password of an adopted instance is not known and may be in use, so the Secret has to be
+// created with the username and password of one of its users beforehand.
+func (c *Controller) completeConnectionSecret(database *googlev1.Database, secret *corev1.Secret, name string, inst *sqladmin.DatabaseInstance) error {
+	if secret == nil || len(secret.Data[connectionUsername]) == 0 || len(secret.Data[connectionPassword]) == 0 {
+		return RequeueAfter(connectionSecretPollInterval, "database '%s' was adopted, create secret '%s' with the %s and %s of one of its users", database.Name, name, connectionUsername, connectionPassword)
+	}
+
+	data := make(map[string][]byte, len(secret.Data))
+	for k, v := range secret.Data {
+		data[k] = v
+	}
+	for k, v := range connectionData(inst, string(secret.Data[connectionUsername]), string(secret.Data[connectionPassword])) {
+		data[k] = v
+	}
+
+	if !secretDataEqual(secret.Data, data) {
+		secret = secret.DeepCopy()
+		secret.Data = data
+		if _, err := c.Kubernetes.CoreV1().Secrets(database.Namespace).Update(secret); err != nil {
+			return fmt.Errorf("error updating secret '%s/%s': %s", database.Namespace, name, err.Error())
+		}
+	}
+
+	database.Status.ConnectionSecret = name
+	return nil
+}
+
+// connectionData returns the contents of the connection Secret of a Cloud SQL instance.
+func connectionData(inst *sqladmin.DatabaseInstance, username, password string) map[string][]byte {
+	host, port, dsn := databaseEndpoint(inst, username, password)
+	return map[string][]byte{
+		connectionHost:           []byte(host),
+		connectionPort:           []byte(strconv.Itoa(port)),
+		connectionConnectionName: []byte(inst.ConnectionName),
+		connectionUsername:       []byte(username),
+		connectionPassword:       []byte(password),
+		connectionDSN:            []byte(dsn),
+	}
+}
+
+// writeConnectionSecret creates the connection Secret of a database, or updates it if its
+// data or whether its password is pending changed, and returns it as written.
+func (c *Controller) writeConnectionSecret(database *googlev1.Database, secret *corev1.Secret, name string, data map[string][]byte, pending bool) (*corev1.Secret, error) {
+	secrets := c.Kubernetes.CoreV1().Secrets(database.Namespace)
+
+	if secret == nil {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: database.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(database, googlev1.SchemeGroupVersion.WithKind("Database")),
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}
+		if pending {
+			secret.Annotations = map[string]string{passwordPendingAnnotation: "true"}
+		}
+		created, err := secrets.Create(secret)
+		if err != nil {
+			return nil, fmt.Errorf("error creating secret '%s/%s': %s", database.Namespace, name, err.Error())
+		}
+		c.MakeEvent(&database.ObjectMeta, "Database", fmt.Sprintf("created connection secret '%s' for database '%s'", name, database.Name), false)
+		return created, nil
+	}
+
+	if secretDataEqual(secret.Data, data) && (secret.Annotations[passwordPendingAnnotation] == "true") == pending {
+		return secret, nil
+	}
+
+	secret = secret.DeepCopy()
+	secret.Data = data
+	if pending {
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[passwordPendingAnnotation] = "true"
+	} else {
+		delete(secret.Annotations, passwordPendingAnnotation)
+	}
+
+	updated, err := secrets.Update(secret)
+	if err != nil {
+		return nil, fmt.Errorf("error updating secret '%s/%s': %s", database.Namespace, name, err.Error())
+	}
+	return updated, nil
+}
+
+func secretDataEqual(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || string(v) != string(w) {
+			return false
+		}
+	}
+	return true
+}
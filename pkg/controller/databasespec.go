@@ -0,0 +1,146 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/sqladmin/v1beta4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// The versions of each engine Cloud SQL supports, newest first. The first one is the default.
+var databaseVersions = map[string][]string{
+	"MYSQL":    {"8.4", "8.0", "5.7", "5.6"},
+	"POSTGRES": {"17", "16", "15", "14", "13", "12", "11", "10", "9.6"},
+}
+
+// The default tier of each engine. PostgreSQL instances can not use the predefined db-n1
+// machine types, only custom ones.
+var databaseDefaultTiers = map[string]string{
+	"MYSQL":    "db-n1-standard-1",
+	"POSTGRES": "db-custom-1-3840",
+}
+
+var tierPattern = regexp.MustCompile(`^db-[a-z0-9]+(-[a-z0-9]+)*$`)
+
+const (
+	minDatabaseDiskSize = 10
+	maxDatabaseDiskSize = 30720
+)
+
+// databaseSettings are the settings of a Cloud SQL instance, taken from the spec of a
+// Database with the defaults filled in.
+type databaseSettings struct {
+	DatabaseVersion   string
+	Tier              string
+	StorageType       string
+	DiskSize          int64
+	StorageAutoResize bool
+	AvailabilityType  string
+}
+
+// databaseSpecSettings validates the spec of a database and returns its settings. All errors
+// are permanent.
+func databaseSpecSettings(spec *googlev1.DatabaseSpec) (*databaseSettings, error) {
+	engine := strings.ToUpper(spec.Type)
+	switch engine {
+	case "":
+		engine = "MYSQL"
+	case "POSTGRESQL":
+		engine = "POSTGRES"
+	}
+
+	versions, ok := databaseVersions[engine]
+	if !ok {
+		return nil, NewPermanentError("unknown database type '%s', must be MYSQL or POSTGRES", spec.Type)
+	}
+
+	version := spec.Version
+	if version == "" {
+		version = versions[0]
+	} else if !contains(versions, version) {
+		return nil, NewPermanentError("unsupported version '%s' for database type %s, must be one of %s", version, engine, strings.Join(versions, ", "))
+	}
+
+	s := &databaseSettings{
+		DatabaseVersion:   engine + "_" + strings.Replace(version, ".", "_", -1),
+		Tier:              spec.Tier,
+		StorageType:       spec.StorageType,
+		DiskSize:          spec.DiskSize,
+		StorageAutoResize: true,
+		AvailabilityType:  spec.AvailabilityType,
+	}
+
+	if s.Tier == "" {
+		s.Tier = databaseDefaultTiers[engine]
+	} else if !tierPattern.MatchString(s.Tier) {
+		return nil, NewPermanentError("invalid tier '%s'", s.Tier)
+	} else if engine == "POSTGRES" && strings.HasPrefix(s.Tier, "db-n1-") {
+		return nil, NewPermanentError("tier '%s' is not available for POSTGRES, use a db-custom tier", s.Tier)
+	}
+
+	switch s.StorageType {
+	case "":
+		s.StorageType = "PD_SSD"
+	case "PD_SSD", "PD_HDD":
+	default:
+		return nil, NewPermanentError("invalid storageType '%s', must be PD_SSD or PD_HDD", s.StorageType)
+	}
+
+	if s.DiskSize == 0 {
+		s.DiskSize = minDatabaseDiskSize
+	} else if s.DiskSize < minDatabaseDiskSize || s.DiskSize > maxDatabaseDiskSize {
+		return nil, NewPermanentError("invalid disksize %d, must be between %d and %d GB", s.DiskSize, minDatabaseDiskSize, maxDatabaseDiskSize)
+	}
+
+	if spec.StorageAutoResize != nil {
+		s.StorageAutoResize = *spec.StorageAutoResize
+	}
+
+	switch s.AvailabilityType {
+	case "":
+		s.AvailabilityType = "ZONAL"
+	case "ZONAL", "REGIONAL":
+	default:
+		return nil, NewPermanentError("invalid availabilityType '%s', must be ZONAL or REGIONAL", s.AvailabilityType)
+	}
+
+	if err := validateAuthorizedNetworks(spec.AuthorizedNetworks); err != nil {
+		return nil, err
+	}
+
+	if spec.InstanceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.InstanceSelector); err != nil {
+			return nil, NewPermanentError("invalid instanceSelector: %s", err.Error())
+		}
+	}
+
+	return s, nil
+}
+
+// instance returns a new Cloud SQL instance with the settings.
+func (s *databaseSettings) instance(name string) *sqladmin.DatabaseInstance {
+	return &sqladmin.DatabaseInstance{
+		Name:            name,
+		BackendType:     "SECOND_GEN",
+		DatabaseVersion: s.DatabaseVersion,
+		Settings: &sqladmin.Settings{
+			Tier:              s.Tier,
+			DataDiskType:      s.StorageType,
+			DataDiskSizeGb:    s.DiskSize,
+			StorageAutoResize: &s.StorageAutoResize,
+			AvailabilityType:  s.AvailabilityType,
+		},
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
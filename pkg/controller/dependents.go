@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// projectIndex indexes Instances and Databases by the Project they reference, as
+// "namespace/project". Objects that do not set spec.project are indexed as "namespace/",
+// and by the default project recorded in their status.
+const projectIndex = "project"
+
+func projectIndexKey(namespace, project string) string {
+	return namespace + "/" + project
+}
+
+func projectIndexKeys(namespace, specProject, statusProject string) []string {
+	keys := []string{projectIndexKey(namespace, specProject)}
+	if specProject == "" && statusProject != "" {
+		keys = append(keys, projectIndexKey(namespace, statusProject))
+	}
+	return keys
+}
+
+// metadataIndex indexes Instances by the ConfigMaps and Secrets their metadata is read from,
+// as "configmap/namespace/name" and "secret/namespace/name".
+const metadataIndex = "metadata"
+
+func instanceMetadataIndexFunc(obj interface{}) ([]string, error) {
+	instance, ok := obj.(*googlev1.Instance)
+	if !ok {
+		return nil, nil
+	}
+
+	var keys []string
+	for _, item := range instance.Spec.Metadata {
+		if item.ValueFrom == nil {
+			continue
+		}
+		if ref := item.ValueFrom.ConfigMapKeyRef; ref != nil {
+			keys = append(keys, "configmap/"+instance.Namespace+"/"+ref.Name)
+		}
+		if ref := item.ValueFrom.SecretKeyRef; ref != nil {
+			keys = append(keys, "secret/"+instance.Namespace+"/"+ref.Name)
+		}
+	}
+	for _, key := range instance.Spec.SSHKeys {
+		keys = append(keys, "secret/"+instance.Namespace+"/"+key.SecretKeyRef.Name)
+	}
+
+	return uniqueSorted(keys), nil
+}
+
+func instanceProjectIndexFunc(obj interface{}) ([]string, error) {
+	instance, ok := obj.(*googlev1.Instance)
+	if !ok {
+		return nil, nil
+	}
+	return projectIndexKeys(instance.Namespace, instance.Spec.Project, instance.Status.Project), nil
+}
+
+func databaseProjectIndexFunc(obj interface{}) ([]string, error) {
+	database, ok := obj.(*googlev1.Database)
+	if !ok {
+		return nil, nil
+	}
+	return projectIndexKeys(database.Namespace, database.Spec.Project, database.Status.Project), nil
+}
+
+// InitializeDependencies sets up the indexers and event handlers that requeue Instances
+// and Databases when their Project or ClusterProject, the Secret of the project, or the
+// labels of their namespace change, Databases when an Instance they select changes, and
+// Instances when a ConfigMap or Secret their metadata is read from changes.
+// Must be called after Initialize and before Start.
+func (c *Controller) InitializeDependencies() {
+	instanceInformer := c.GoogleFactory.Google().V1().Instances().Informer()
+	instanceInformer.AddIndexers(cache.Indexers{projectIndex: instanceProjectIndexFunc, metadataIndex: instanceMetadataIndexFunc})
+
+	databaseInformer := c.GoogleFactory.Google().V1().Databases().Informer()
+	databaseInformer.AddIndexers(cache.Indexers{projectIndex: databaseProjectIndexFunc})
+
+	c.GoogleFactory.Google().V1().Projects().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if project, ok := obj.(*googlev1.Project); ok {
+				c.enqueueProjectDependents(project.Namespace, project.Name)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			o, ok1 := old.(*googlev1.Project)
+			n, ok2 := new.(*googlev1.Project)
+			if ok1 && ok2 && o.ResourceVersion != n.ResourceVersion {
+				c.enqueueProjectDependents(n.Namespace, n.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if project, ok := obj.(*googlev1.Project); ok {
+				c.enqueueProjectDependents(project.Namespace, project.Name)
+			}
+		},
+	})
+
+	c.GoogleFactory.Google().V1().ClusterProjects().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if project, ok := obj.(*googlev1.ClusterProject); ok {
+				c.enqueueClusterProjectDependents(project.Name)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			o, ok1 := old.(*googlev1.ClusterProject)
+			n, ok2 := new.(*googlev1.ClusterProject)
+			if ok1 && ok2 && o.ResourceVersion != n.ResourceVersion {
+				c.enqueueClusterProjectDependents(n.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if project, ok := obj.(*googlev1.ClusterProject); ok {
+				c.enqueueClusterProjectDependents(project.Name)
+			}
+		},
+	})
+
+	// The authorized networks of Databases depend on the external IPs of the Instances
+	// matched by their instanceSelector.
+	instanceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if instance, ok := obj.(*googlev1.Instance); ok {
+				c.enqueueInstanceDatabases(instance)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			o, ok1 := old.(*googlev1.Instance)
+			n, ok2 := new.(*googlev1.Instance)
+			if ok1 && ok2 && o.ResourceVersion != n.ResourceVersion {
+				c.enqueueInstanceDatabases(n)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if instance, ok := obj.(*googlev1.Instance); ok {
+				c.enqueueInstanceDatabases(instance)
+			}
+		},
+	})
+
+	// A label change can allow or disallow a namespace to use a ClusterProject, an annotation
+	// can change its default project.
+	c.KubernetesFactory.Core().V1().Namespaces().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			o, ok1 := old.(*corev1.Namespace)
+			n, ok2 := new.(*corev1.Namespace)
+			if ok1 && ok2 && (!labels.Equals(o.Labels, n.Labels) || o.Annotations[defaultProjectAnnotation] != n.Annotations[defaultProjectAnnotation]) {
+				c.enqueueNamespace(n.Name)
+			}
+		},
+	})
+
+	c.KubernetesFactory.Core().V1().ConfigMaps().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if configMap, ok := obj.(*corev1.ConfigMap); ok {
+				c.enqueueMetadataDependents("configmap/" + configMap.Namespace + "/" + configMap.Name)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			o, ok1 := old.(*corev1.ConfigMap)
+			n, ok2 := new.(*corev1.ConfigMap)
+			if ok1 && ok2 && o.ResourceVersion != n.ResourceVersion {
+				c.enqueueMetadataDependents("configmap/" + n.Namespace + "/" + n.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if configMap, ok := obj.(*corev1.ConfigMap); ok {
+				c.enqueueMetadataDependents("configmap/" + configMap.Namespace + "/" + configMap.Name)
+			}
+		},
+	})
+
+	c.KubernetesFactory.Core().V1().Secrets().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if secret, ok := obj.(*corev1.Secret); ok {
+				c.enqueueSecretDependents(secret)
+			}
+		},
+		UpdateFunc: func(old, new interface{}) {
+			o, ok1 := old.(*corev1.Secret)
+			n, ok2 := new.(*corev1.Secret)
+			if ok1 && ok2 && o.ResourceVersion != n.ResourceVersion {
+				c.enqueueSecretDependents(n)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if secret, ok := obj.(*corev1.Secret); ok {
+				c.enqueueSecretDependents(secret)
+			}
+		},
+	})
+}
+
+// enqueueProjectDependents adds all Instances and Databases that use the given Project to
+// their queues. Objects without spec.project are always added, as any Project can change
+// which one is the default.
+func (c *Controller) enqueueProjectDependents(namespace, name string) {
+	keys := []string{projectIndexKey(namespace, name), projectIndexKey(namespace, "")}
+
+	for _, key := range keys {
+		c.enqueueIndexed(c.instanceIndexer(), c.InstanceQueue.Add, key)
+		c.enqueueIndexed(c.databaseIndexer(), c.DatabaseQueue.Add, key)
+	}
+}
+
+// enqueueClusterProjectDependents adds all Instances and Databases that may use the given
+// ClusterProject to their queues. A Project of the same name in a namespace takes precedence,
+// but that is sorted out when the objects are processed.
+func (c *Controller) enqueueClusterProjectDependents(name string) {
+	namespaces, err := c.NamespaceLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("error listing namespaces: %s", err.Error())
+		return
+	}
+
+	for _, ns := range namespaces {
+		c.enqueueProjectDependents(ns.Name, name)
+	}
+}
+
+// enqueueNamespace adds all Instances and Databases in the namespace to their queues.
+func (c *Controller) enqueueNamespace(namespace string) {
+	instances, err := c.InstanceLister.Instances(namespace).List(labels.Everything())
+	if err != nil {
+		log.Errorf("error listing instances in namespace '%s': %s", namespace, err.Error())
+	}
+	for _, instance := range instances {
+		if key, err := cache.MetaNamespaceKeyFunc(instance); err == nil {
+			c.InstanceQueue.Add(key)
+		}
+	}
+
+	databases, err := c.DatabaseLister.Databases(namespace).List(labels.Everything())
+	if err != nil {
+		log.Errorf("error listing databases in namespace '%s': %s", namespace, err.Error())
+	}
+	for _, database := range databases {
+		if key, err := cache.MetaNamespaceKeyFunc(database); err == nil {
+			c.DatabaseQueue.Add(key)
+		}
+	}
+}
+
+// enqueueInstanceDatabases adds the Databases in the namespace of the Instance to the queue,
+// as their instanceSelector may match it.
+func (c *Controller) enqueueInstanceDatabases(instance *googlev1.Instance) {
+	databases, err := c.DatabaseLister.Databases(instance.Namespace).List(labels.Everything())
+	if err != nil {
+		log.Errorf("error listing databases in namespace '%s': %s", instance.Namespace, err.Error())
+		return
+	}
+
+	for _, database := range databases {
+		if database.Spec.InstanceSelector == nil {
+			continue
+		}
+		if key, err := cache.MetaNamespaceKeyFunc(database); err == nil {
+			c.DatabaseQueue.Add(key)
+		}
+	}
+}
+
+// enqueueMetadataDependents adds the Instances whose metadata is read from the ConfigMap or
+// Secret to the queue.
+func (c *Controller) enqueueMetadataDependents(indexKey string) {
+	objs, err := c.instanceIndexer().ByIndex(metadataIndex, indexKey)
+	if err != nil {
+		log.Errorf("error looking up instances using '%s': %s", indexKey, err.Error())
+		return
+	}
+
+	for _, obj := range objs {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			c.InstanceQueue.Add(key)
+		}
+	}
+}
+
+// instanceIndexer returns the indexer of the shared Instance informer, with the indexes
+// added by InitializeDependencies.
+func (c *Controller) instanceIndexer() cache.Indexer {
+	return c.GoogleFactory.Google().V1().Instances().Informer().GetIndexer()
+}
+
+// databaseIndexer returns the indexer of the shared Database informer.
+func (c *Controller) databaseIndexer() cache.Indexer {
+	return c.GoogleFactory.Google().V1().Databases().Informer().GetIndexer()
+}
+
+func (c *Controller) enqueueIndexed(indexer cache.Indexer, add func(interface{}), indexKey string) {
+	objs, err := indexer.ByIndex(projectIndex, indexKey)
+	if err != nil {
+		log.Errorf("error looking up dependents of project '%s': %s", indexKey, err.Error())
+		return
+	}
+
+	for _, obj := range objs {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			add(key)
+		}
+	}
+}
+
+// enqueueSecretDependents adds the Projects and ClusterProjects using the Secret for their
+// service account, and everything that depends on those, to the queues. A connection
+// Secret adds the Database it belongs to, and Instances read metadata from Secrets.
+func (c *Controller) enqueueSecretDependents(secret *corev1.Secret) {
+	c.enqueueMetadataDependents("secret/" + secret.Namespace + "/" + secret.Name)
+
+	// The connection Secret of a Database is recreated when it is deleted or changed.
+	if owner := metav1.GetControllerOf(secret); owner != nil && owner.Kind == "Database" && owner.APIVersion == googlev1.SchemeGroupVersion.String() {
+		c.DatabaseQueue.Add(secret.Namespace + "/" + owner.Name)
+	}
+
+	if secret.Namespace == c.CredentialsNamespace {
+		c.enqueueClusterProjectSecretDependents(secret)
+	}
+
+	projects, err := c.ProjectLister.Projects(secret.Namespace).List(labels.Everything())
+	if err != nil {
+		log.Errorf("error listing projects in namespace '%s': %s", secret.Namespace, err.Error())
+		return
+	}
+
+	for _, project := range projects {
+		creds := projectCredentials(&project.Spec)
+		if creds.Source != googlev1.CredentialSourceSecret || creds.SecretName != secret.Name {
+			continue
+		}
+		if key, err := cache.MetaNamespaceKeyFunc(project); err == nil {
+			c.ProjectQueue.Add(key)
+		}
+		c.enqueueProjectDependents(project.Namespace, project.Name)
+	}
+}
+
+func (c *Controller) enqueueClusterProjectSecretDependents(secret *corev1.Secret) {
+	projects, err := c.ClusterProjectLister.List(labels.Everything())
+	if err != nil {
+		log.Errorf("error listing clusterprojects: %s", err.Error())
+		return
+	}
+
+	for _, project := range projects {
+		creds := projectCredentials(&project.Spec.ProjectSpec)
+		if creds.Source != googlev1.CredentialSourceSecret || creds.SecretName != secret.Name {
+			continue
+		}
+		c.ClusterProjectQueue.Add(project.Name)
+		c.enqueueClusterProjectDependents(project.Name)
+	}
+}
+
+// projectDependents returns the Instances and Databases, as "kind namespace/name", that use
+// a project and still have to be cleaned up with its credentials.
+func (c *Controller) projectDependents(gp *googleProject) ([]string, error) {
+	var dependents []string
+
+	instances, err := c.InstanceLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing instances: %s", err.Error())
+	}
+	for _, instance := range instances {
+		if hasFinalizer(&instance.ObjectMeta) && c.usesProject(instance.Namespace, instance.Spec.Project, instance.Status.Project, gp) {
+			dependents = append(dependents, "instance "+instance.Namespace+"/"+instance.Name)
+		}
+	}
+
+	databases, err := c.DatabaseLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error listing databases: %s", err.Error())
+	}
+	for _, database := range databases {
+		if hasFinalizer(&database.ObjectMeta) && c.usesProject(database.Namespace, database.Spec.Project, database.Status.Project, gp) {
+			dependents = append(dependents, "database "+database.Namespace+"/"+database.Name)
+		}
+	}
+
+	sort.Strings(dependents)
+	return dependents, nil
+}
+
+// usesProject reports whether an object in namespace uses the project when it is deleted,
+// which is the one recorded in its status. A Project takes precedence over a ClusterProject
+// of the same name.
+func (c *Controller) usesProject(namespace, specProject, statusProject string, gp *googleProject) bool {
+	name := statusProject
+	if name == "" {
+		name = specProject
+	}
+	if name != gp.Name {
+		return false
+	}
+
+	if gp.Kind == "Project" {
+		return namespace == gp.Namespace
+	}
+	_, err := c.ProjectLister.Projects(namespace).Get(name)
+	return errors.IsNotFound(err)
+}
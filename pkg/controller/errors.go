@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// A PermanentError is returned by the handlers when retrying will not help, e.g. because
+// the spec of an object is invalid. The key is not retried with backoff, but is still
+// processed again on every resync, so that changes to other objects it depends on are
+// picked up.
+type PermanentError struct {
+	message string
+}
+
+func (e *PermanentError) Error() string {
+	return e.message
+}
+
+func NewPermanentError(format string, a ...interface{}) error {
+	return &PermanentError{message: fmt.Sprintf(format, a...)}
+}
+
+// IsPermanentError reports whether err should not be retried. Errors from the Google APIs
+// are permanent if the request itself was rejected as invalid. Quota (429) and server side
+// (5xx) errors, as well as everything else, are considered transient.
+func IsPermanentError(err error) bool {
+	switch e := err.(type) {
+	case *PermanentError:
+		return true
+	case *googleapi.Error:
+		return e.Code == 400
+	}
+	return false
+}
+
+// keepPermanent returns err as a PermanentError if cause is permanent. This is used to
+// keep the classification of a Google API error when it is wrapped into a new message.
+func keepPermanent(cause error, err error) error {
+	if err == nil || !IsPermanentError(cause) {
+		return err
+	}
+	return &PermanentError{message: err.Error()}
+}
+
+// A RequeueError is not a failure. It asks for the key to be processed again after the
+// given duration, e.g. to check on a running operation.
+type RequeueError struct {
+	After  time.Duration
+	reason string
+}
+
+func (e *RequeueError) Error() string {
+	return fmt.Sprintf("requeue after %s: %s", e.After, e.reason)
+}
+
+func RequeueAfter(after time.Duration, format string, a ...interface{}) error {
+	return &RequeueError{After: after, reason: fmt.Sprintf(format, a...)}
+}
+
+// isNotFound reports whether err is a 404 from the Google APIs.
+func isNotFound(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code == 404
+}
+
+// handleError requeues the key of an object whose handler returned err, and returns the
+// error the generated worker logs. The worker forgets the key when nil is returned, so
+// RequeueErrors return nil after adding the key again. Other errors are retried with backoff
+// up to MaxRetries times, unless they are permanent.
+func (c *Controller) handleError(queue workqueue.RateLimitingInterface, meta *metav1.ObjectMeta, kind string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	key, kerr := cache.MetaNamespaceKeyFunc(meta)
+	if kerr != nil {
+		return err
+	}
+
+	if r, ok := err.(*RequeueError); ok {
+		queue.AddAfter(key, r.After)
+		log.Debugf("%s: %s", key, r.Error())
+		return nil
+	}
+
+	if IsPermanentError(err) {
+		queue.Forget(key)
+		return fmt.Errorf("not retrying: %s", err.Error())
+	}
+
+	if queue.NumRequeues(key) < c.MaxRetries {
+		queue.AddRateLimited(key)
+		return fmt.Errorf("requeuing: %s", err.Error())
+	}
+
+	queue.Forget(key)
+	message := fmt.Sprintf("giving up after %d retries: %s", c.MaxRetries, err.Error())
+	_ = c.MakeEvent(meta, kind, message, true)
+	return fmt.Errorf("%s", message)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// expiryDeadline returns when an object expires, or the zero time if it does not.
+func expiryDeadline(meta *metav1.ObjectMeta, expiry *googlev1.Expiry) (time.Time, error) {
+	if expiry == nil {
+		return time.Time{}, nil
+	}
+
+	switch {
+	case expiry.At != nil && expiry.After != nil:
+		return time.Time{}, NewPermanentError("expiry of '%s' needs either at or after, not both", meta.Name)
+	case expiry.At != nil:
+		return expiry.At.Time, nil
+	case expiry.After != nil:
+		if expiry.After.Duration <= 0 {
+			return time.Time{}, NewPermanentError("expiry of '%s' must be after a positive duration", meta.Name)
+		}
+		return meta.CreationTimestamp.Add(expiry.After.Duration), nil
+	}
+
+	return time.Time{}, nil
+}
+
+// checkExpiry deletes an object once its expiry deadline has passed, using remove, and
+// reports whether it did. Within ExpiryWarning of the deadline, the Expiring condition is
+// set and a Warning event emitted once per deadline.
+func (c *Controller) checkExpiry(meta *metav1.ObjectMeta, kind string, deadline time.Time, status *googlev1.ResourceStatus, remove func() error) (bool, error) {
+	now := time.Now()
+
+	if deadline.IsZero() || now.Before(deadline.Add(-c.ExpiryWarning)) {
+		if cond := getCondition(status, googlev1.ConditionExpiring); cond != nil && cond.Status != corev1.ConditionFalse {
+			setCondition(status, googlev1.ConditionExpiring, corev1.ConditionFalse, "Extended", "")
+		}
+		return false, nil
+	}
+
+	if now.Before(deadline) {
+		message := fmt.Sprintf("%s '%s' expires at %s and will be deleted", kind, meta.Name, deadline.Format(time.RFC3339))
+		cond := getCondition(status, googlev1.ConditionExpiring)
+		if cond == nil || cond.Status != corev1.ConditionTrue || cond.Message != message {
+			_ = c.MakeEvent(meta, kind, message, true)
+		}
+		setCondition(status, googlev1.ConditionExpiring, corev1.ConditionTrue, "DeadlineApproaching", message)
+		return false, nil
+	}
+
+	if meta.DeletionTimestamp != nil {
+		return true, nil
+	}
+
+	if err := remove(); err != nil {
+		return false, fmt.Errorf("error deleting expired %s '%s/%s': %s", kind, meta.Namespace, meta.Name, err.Error())
+	}
+
+	message := fmt.Sprintf("%s '%s' expired at %s, deleting it", kind, meta.Name, deadline.Format(time.RFC3339))
+	_ = c.MakeEvent(meta, kind, message, true)
+	setCondition(status, googlev1.ConditionExpiring, corev1.ConditionTrue, "Expired", message)
+	return true, nil
+}
+
+// requeueForExpiry makes sure an object is processed again when it needs to be warned about
+// or deleted because it expires. The error of the reconcile is kept, unless it is nil or a
+// RequeueError that would be too late.
+func (c *Controller) requeueForExpiry(err error, kind, name string, deadline time.Time) error {
+	if deadline.IsZero() {
+		return err
+	}
+
+	at := deadline
+	if warning := deadline.Add(-c.ExpiryWarning); time.Now().Before(warning) {
+		at = warning
+	}
+	after := time.Until(at) + time.Second
+
+	switch e := err.(type) {
+	case nil:
+		return RequeueAfter(after, "waiting for the expiry of %s '%s' at %s", kind, name, deadline.Format(time.RFC3339))
+	case *RequeueError:
+		if e.After > after {
+			e.After = after
+		}
+	}
+	return err
+}
+
+func optionalTime(t time.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	mt := metav1.NewTime(t)
+	return &mt
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The finalizer keeps Instances and Databases around until the resource in Google Cloud
+// is gone, and Projects and ClusterProjects until no Instance or Database needs them for
+// that anymore.
+const finalizerName = "google.cloudcrd.weisnix.org/cleanup"
+
+func hasFinalizer(meta *metav1.ObjectMeta) bool {
+	for _, f := range meta.Finalizers {
+		if f == finalizerName {
+			return true
+		}
+	}
+	return false
+}
+
+func addFinalizer(meta *metav1.ObjectMeta) {
+	if !hasFinalizer(meta) {
+		meta.Finalizers = append(meta.Finalizers, finalizerName)
+	}
+}
+
+func removeFinalizer(meta *metav1.ObjectMeta) {
+	var finalizers []string
+	for _, f := range meta.Finalizers {
+		if f != finalizerName {
+			finalizers = append(finalizers, f)
+		}
+	}
+	meta.Finalizers = finalizers
+}
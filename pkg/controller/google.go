@@ -4,32 +4,214 @@ import (
 	"fmt"
 	"golang.org/x/oauth2/google"
 	"golang.org/x/oauth2"
+	"google.golang.org/api/iamcredentials/v1"
 	"net/http"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"sync"
+	"time"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
 )
 
-func (c *Controller) NewGoogleClient(projectName, namespace, scope string) (*http.Client, error) {
-	project, err := c.ProjectLister.Projects(namespace).Get(projectName)
+// credentialKey identifies a cached client, there is one per project and scope.
+type credentialKey struct {
+	kind      string
+	namespace string
+	project   string
+	scope     string
+}
+
+type cachedClient struct {
+	// The Secret name and resourceVersion the client was created from.
+	version string
+	client  *http.Client
+}
+
+// credentialCache keeps one authenticated client per project and scope, so the key is not
+// parsed and a new token fetched on every reconcile. An entry is replaced as soon as the
+// Secret it was created from changes.
+type credentialCache struct {
+	sync.Mutex
+	clients map[credentialKey]*cachedClient
+}
+
+func (cc *credentialCache) get(key credentialKey, version string, create func() (*http.Client, error)) (*http.Client, error) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	if cached, ok := cc.clients[key]; ok && cached.version == version {
+		return cached.client, nil
+	}
+
+	client, err := create()
 	if err != nil {
-		return nil, fmt.Errorf("error getting project '%s-%s': %s", namespace, projectName, err.Error())
+		return nil, err
+	}
+
+	if cc.clients == nil {
+		cc.clients = map[credentialKey]*cachedClient{}
 	}
+	cc.clients[key] = &cachedClient{version: version, client: client}
+
+	return client, nil
+}
 
-	secret, err := c.Kubernetes.CoreV1().Secrets(namespace).Get(project.Spec.ServiceAccountSecret, metav1.GetOptions{})
+// projectCredentials returns the credentials of a project with the defaults filled in.
+func projectCredentials(spec *googlev1.ProjectSpec) googlev1.ProjectCredentials {
+	var creds googlev1.ProjectCredentials
+	if spec.Credentials != nil {
+		creds = *spec.Credentials
+	}
+
+	if creds.Source == "" {
+		creds.Source = googlev1.CredentialSourceSecret
+	}
+	if creds.Source == googlev1.CredentialSourceSecret {
+		if creds.SecretName == "" {
+			creds.SecretName = spec.ServiceAccountSecret
+		}
+		if creds.SecretKey == "" {
+			creds.SecretKey = "json"
+		}
+	}
+
+	return creds
+}
+
+// validateCredentials checks the credentials of a project of the given kind. The sources
+// ApplicationDefault and Impersonation act as the controller itself, so they may only be used
+// by ClusterProjects, which only cluster administrators create. Otherwise anyone allowed to
+// create a Project in some namespace could use the identity of the controller.
+func validateCredentials(kind string, creds googlev1.ProjectCredentials) error {
+	switch creds.Source {
+	case googlev1.CredentialSourceSecret:
+		if creds.SecretName == "" {
+			return fmt.Errorf("credentials from a Secret need serviceaccountsecret or credentials.secretName")
+		}
+	case googlev1.CredentialSourceApplicationDefault, googlev1.CredentialSourceImpersonation:
+		if kind != "ClusterProject" {
+			return fmt.Errorf("credentials source %s can only be used by a ClusterProject", creds.Source)
+		}
+		if creds.Source == googlev1.CredentialSourceImpersonation && creds.ImpersonateServiceAccount == "" {
+			return fmt.Errorf("credentials by impersonation need credentials.impersonateServiceAccount")
+		}
+	default:
+		return fmt.Errorf("unknown credentials source '%s'", creds.Source)
+	}
+	return nil
+}
+
+// credentialsVersion identifies the credentials of a project. It changes whenever they do.
+func (c *Controller) credentialsVersion(project *googleProject) (string, error) {
+	creds := projectCredentials(project.Spec)
+
+	switch creds.Source {
+	case googlev1.CredentialSourceApplicationDefault:
+		return "adc", nil
+	case googlev1.CredentialSourceImpersonation:
+		return "impersonate:" + strings.Join(creds.Delegates, ",") + ">" + creds.ImpersonateServiceAccount, nil
+	}
+
+	namespace := project.SecretNamespace()
+	secret, err := c.SecretLister.Secrets(namespace).Get(creds.SecretName)
 	if err != nil {
-		return nil, fmt.Errorf("error getting secret '%s-%s' for serviceaccount of project '%s-%s': %s", namespace, project.Spec.ServiceAccountSecret, namespace, projectName, err.Error())
+		return "", fmt.Errorf("error getting secret '%s-%s' for serviceaccount of project '%s': %s", namespace, creds.SecretName, project, err.Error())
+	}
+	return "secret:" + secret.Name + "/" + creds.SecretKey + "@" + secret.ResourceVersion, nil
+}
+
+func (c *Controller) NewGoogleClient(project *googleProject, scope string) (*http.Client, error) {
+	creds := projectCredentials(project.Spec)
+	if err := validateCredentials(project.Kind, creds); err != nil {
+		return nil, NewPermanentError("invalid credentials for project '%s': %s", project, err.Error())
 	}
 
-	cred := secret.Data["json"]
-	if len(cred) == 0 {
-		return nil, fmt.Errorf("secret '%s-%s' for serviceaccount of project '%s-%s' does not contain a field 'json'", namespace, project.Spec.ServiceAccountSecret, namespace, projectName)
+	key := credentialKey{kind: project.Kind, namespace: project.Namespace, project: project.Name, scope: scope}
+	namespace := project.SecretNamespace()
+
+	switch creds.Source {
+	case googlev1.CredentialSourceApplicationDefault:
+		return c.credentials.get(key, "adc", func() (*http.Client, error) {
+			client, err := google.DefaultClient(oauth2.NoContext, scope)
+			if err != nil {
+				return nil, fmt.Errorf("error getting application default credentials for project '%s': %s", project, err.Error())
+			}
+			return client, nil
+		})
+
+	case googlev1.CredentialSourceImpersonation:
+		version := "impersonate:" + strings.Join(creds.Delegates, ",") + ">" + creds.ImpersonateServiceAccount
+		return c.credentials.get(key, version, func() (*http.Client, error) {
+			base, err := google.DefaultClient(oauth2.NoContext, iamcredentials.CloudPlatformScope)
+			if err != nil {
+				return nil, fmt.Errorf("error getting application default credentials for project '%s': %s", project, err.Error())
+			}
+
+			iamc, err := iamcredentials.New(base)
+			if err != nil {
+				return nil, fmt.Errorf("error creating iamcredentials client for project '%s': %s", project, err.Error())
+			}
+
+			ts := &impersonatedTokenSource{
+				service:   iamc,
+				target:    creds.ImpersonateServiceAccount,
+				delegates: creds.Delegates,
+				scope:     scope,
+			}
+			return oauth2.NewClient(oauth2.NoContext, oauth2.ReuseTokenSource(nil, ts)), nil
+		})
 	}
 
-	conf, err := google.JWTConfigFromJSON(cred, scope)
+	secret, err := c.SecretLister.Secrets(namespace).Get(creds.SecretName)
 	if err != nil {
-		return nil, fmt.Errorf("error creating authentication for project '%s-%s': %s", namespace, projectName, err.Error())
+		return nil, fmt.Errorf("error getting secret '%s-%s' for serviceaccount of project '%s': %s", namespace, creds.SecretName, project, err.Error())
 	}
 
-	client := conf.Client(oauth2.NoContext)
+	version := "secret:" + secret.Name + "/" + creds.SecretKey + "@" + secret.ResourceVersion
 
-	return client, nil
-}
\ No newline at end of file
+	return c.credentials.get(key, version, func() (*http.Client, error) {
+		cred := secret.Data[creds.SecretKey]
+		if len(cred) == 0 {
+			return nil, fmt.Errorf("secret '%s-%s' for serviceaccount of project '%s' does not contain a field '%s'", namespace, creds.SecretName, project, creds.SecretKey)
+		}
+
+		conf, err := google.JWTConfigFromJSON(cred, scope)
+		if err != nil {
+			return nil, fmt.Errorf("error creating authentication for project '%s': %s", project, err.Error())
+		}
+
+		return conf.Client(oauth2.NoContext), nil
+	})
+}
+
+// impersonatedTokenSource gets short lived access tokens for a service account from the
+// IAM Credentials API, optionally through a chain of delegates.
+type impersonatedTokenSource struct {
+	service   *iamcredentials.Service
+	target    string
+	delegates []string
+	scope     string
+}
+
+func (s *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	var delegates []string
+	for _, d := range s.delegates {
+		delegates = append(delegates, "projects/-/serviceAccounts/"+d)
+	}
+
+	resp, err := s.service.Projects.ServiceAccounts.GenerateAccessToken("projects/-/serviceAccounts/"+s.target, &iamcredentials.GenerateAccessTokenRequest{
+		Scope:     []string{s.scope},
+		Delegates: delegates,
+		Lifetime:  "3600s",
+	}).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error impersonating service account '%s': %s", s.target, err.Error())
+	}
+
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing expiry of token for service account '%s': %s", s.target, err.Error())
+	}
+
+	return &oauth2.Token{AccessToken: resp.AccessToken, Expiry: expiry}, nil
+}
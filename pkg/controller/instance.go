@@ -2,11 +2,18 @@ package main
 
 import (
 	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
 )
@@ -14,23 +21,83 @@ import (
 func (c *Controller) InstanceCreatedOrUpdated(instance *googlev1.Instance) error {
 	log.Debugf("processing created or updated instance '%s/%s'", instance.Namespace, instance.Name)
 
-	var projectName string
-	if projectName = instance.Spec.Project; projectName == "" {
-		projectName = "default"
+	if instance.DeletionTimestamp != nil {
+		return c.handleError(c.InstanceQueue, &instance.ObjectMeta, "Instance", c.InstanceDeleted(instance))
 	}
 
-	project, err := c.ProjectLister.Projects(instance.Namespace).Get(projectName)
+	instance = instance.DeepCopy()
+	status := instance.Status.DeepCopy()
+
+	deadline, err := expiryDeadline(&instance.ObjectMeta, instance.Spec.Expiry)
+	if err == nil {
+		instance.Status.ExpiresAt = optionalTime(deadline)
+
+		var expired bool
+		expired, err = c.checkExpiry(&instance.ObjectMeta, "Instance", deadline, &instance.Status.ResourceStatus, func() error {
+			return c.GoogleClient.GoogleV1().Instances(instance.Namespace).Delete(instance.Name, &metav1.DeleteOptions{})
+		})
+		if expired {
+			// The object is being deleted, its status would only conflict.
+			return nil
+		}
+	}
+	if err == nil {
+		err = c.reconcileInstance(instance)
+		err = c.requeueForExpiry(err, "instance", instance.Name, deadline)
+	}
+
+	setSynced(&instance.Status.ResourceStatus, instance.Generation, err)
+	if !equality.Semantic.DeepEqual(status, &instance.Status) {
+		if _, serr := c.GoogleClient.GoogleV1().Instances(instance.Namespace).UpdateStatus(instance); serr != nil {
+			log.Errorf("error updating status of instance '%s/%s': %s", instance.Namespace, instance.Name, serr.Error())
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+
+	return c.handleError(c.InstanceQueue, &instance.ObjectMeta, "Instance", err)
+}
+
+func (c *Controller) reconcileInstance(instance *googlev1.Instance) error {
+	if instance.Spec.Type == "" || instance.Spec.Image == "" {
+		_ = c.MakeEvent(&instance.ObjectMeta, "Instance", fmt.Sprintf("instance '%s' needs both a type and an image", instance.Name), true)
+		return NewPermanentError("instance '%s' needs both a type and an image", instance.Name)
+	}
+
+	if err := c.checkExternalName(&instance.ObjectMeta, "Instance", instance.Spec.ExternalName, instance.Status.ExternalName); err != nil {
+		return err
+	}
+
+	// Once the instance exists, it stays in the default project it was created in.
+	projectName := instance.Spec.Project
+	if projectName == "" && instance.Status.ID != "" {
+		projectName = instance.Status.Project
+	}
+
+	project, err := c.resolveProject(&instance.ObjectMeta, "Instance", projectName)
 	if err != nil {
-		return fmt.Errorf("error getting project '%s-%s': %s", instance.Namespace, projectName, err.Error())
+		return err
 	}
+	instance.Status.Project = project.Name
 
-	comp, err := c.ComputeService(projectName, instance.Namespace)
+	// A VM that was not created yet, or is to be adopted, is looked for where it would be
+	// created.
+	zone := instance.Status.Zone
+	if zone == "" {
+		zone, err = desiredInstanceZone(instance, project)
+		if err != nil {
+			return err
+		}
+	}
+
+	comp, err := c.ComputeService(project)
 	if err != nil {
 		return err
 	}
 
 	notfound := false
-	inst, err := comp.Instances.Get(project.Spec.Name, project.Spec.Zone, instance.Name).Do()
+	inst, err := comp.Instances.Get(project.Spec.Name, zone, instanceExternalName(instance)).Do()
 	if err != nil {
 		if gerr, ok := err.(*googleapi.Error); ok {
 			// TODO: better way to handle NotFound?
@@ -44,66 +111,125 @@ func (c *Controller) InstanceCreatedOrUpdated(instance *googlev1.Instance) error
 		}
 	}
 
+	if !notfound {
+		if err := c.adoptInstance(instance, comp, project, inst); err != nil {
+			setReady(&instance.Status.ResourceStatus, false, "NotAdopted", err.Error())
+			return err
+		}
+		setInstanceStatus(&instance.Status, inst)
+	}
+
+	// Added only once the VM is owned or about to be created, as the finalizer marks VMs
+	// created before the labels were introduced as owned.
+	if !hasFinalizer(&instance.ObjectMeta) {
+		addFinalizer(&instance.ObjectMeta)
+		if err := c.updateInstance(instance); err != nil {
+			return err
+		}
+	}
+
+	if err := c.waitForInstanceOperation(instance, comp, project); err != nil {
+		if _, requeue := err.(*RequeueError); !requeue {
+			setReady(&instance.Status.ResourceStatus, false, "OperationFailed", err.Error())
+		}
+		return err
+	}
+
 	if notfound {
 		log.Debugf("instance '%s' not found", instance.Name)
 
+		zone, err := desiredInstanceZone(instance, project)
+		if err != nil {
+			return err
+		}
+
+		serviceAccounts, err := instanceServiceAccounts(instance, project)
+		if err != nil {
+			return err
+		}
+
+		scheduling, err := instanceScheduling(instance)
+		if err != nil {
+			return err
+		}
+
+		labels, err := managedLabels(&instance.ObjectMeta, instance.Spec.Labels)
+		if err != nil {
+			return err
+		}
+
+		metadata, err := c.instanceMetadata(instance)
+		if err != nil {
+			return err
+		}
+
+		networkInterfaces, err := c.instanceNetworkInterfaces(instance, comp, project)
+		if err != nil {
+			return err
+		}
+
+		sourceImage, err := c.instanceSourceImage(instance, comp)
+		if err != nil {
+			return err
+		}
+
 		i := compute.Instance{
-			Name:           instance.Name,
+			Name:           instanceExternalName(instance),
 			MinCpuPlatform: "Automatic",
-			MachineType:    fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", project.Spec.Name, project.Spec.Zone, instance.Spec.Type),
-			//Metadata: &compute.Metadata{
-			//	Items: []*compute.MetadataItems{{
-			//		Key: "ssh-keys",
-			//		Value: &ssh_key,
-			//	},
-			//	}},
-			//Tags: &compute.Tags{
-			//	Items: []string{"sometag"},
-			//},
+			MachineType:    fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", project.Spec.Name, zone, instance.Spec.Type),
+			Labels:         labels,
+			Metadata: &compute.Metadata{
+				Items: metadata,
+			},
+			Tags: &compute.Tags{
+				Items: uniqueSorted(append([]string{}, instance.Spec.Tags...)),
+			},
 			Disks: []*compute.AttachedDisk{{
 				AutoDelete: true,
 				Boot:       true,
 				Type:       "PERSISTENT",
 				InitializeParams: &compute.AttachedDiskInitializeParams{
-					// TODO: Nicer way to choose image.
-					SourceImage: instance.Spec.Image,
+					SourceImage: sourceImage,
 					DiskSizeGb:  instance.Spec.DiskSize,
 				},
 			}},
-			NetworkInterfaces: []*compute.NetworkInterface{
-				&compute.NetworkInterface{
-					// TODO: configurable
-					AccessConfigs: []*compute.AccessConfig{
-						&compute.AccessConfig{
-							Type: "ONE_TO_ONE_NAT",
-							Name: "External NAT",
-						},
-					},
-					Subnetwork: fmt.Sprintf("projects/%s/regions/%s/subnetworks/default", project.Spec.Name, project.Spec.Region),
-				},
-			},
-			ServiceAccounts: []*compute.ServiceAccount{
-				{
-					Email: project.Spec.ServiceAccount,
-					Scopes: []string{
-						compute.DevstorageFullControlScope,
-						compute.ComputeScope,
-					},
-				},
-			},
+			NetworkInterfaces:  networkInterfaces,
+			ServiceAccounts:    serviceAccounts,
+			Scheduling:         scheduling,
+			DeletionProtection: instance.Spec.DeletionProtection,
 		}
 
-		_, err := comp.Instances.Insert(project.Spec.Name, project.Spec.Zone, &i).Do()
+		op, err := comp.Instances.Insert(project.Spec.Name, zone, &i).Do()
 		if err != nil {
-			return c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not create instance '%s': %s", instance.Name, err.Error()))
+			return keepPermanent(err, c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not create instance '%s': %s", instance.Name, err.Error())))
 		} else {
+			setPendingOperation(&instance.ObjectMeta, op.Name)
+			if err := c.updateInstance(instance); err != nil {
+				return err
+			}
+
+			instance.Status.Image = instance.Spec.Image
+			instance.Status.SourceImage = sourceImage
+			instance.Status.Zone = zone
+			instance.Status.ExternalName = i.Name
+			setCondition(&instance.Status.ResourceStatus, googlev1.ConditionUpToDate, corev1.ConditionTrue, "", "")
 			c.MakeEvent(&instance.ObjectMeta, "Instance", fmt.Sprintf("requested provisioning of instance '%s'", instance.Name), false)
-			return nil
+			setReady(&instance.Status.ResourceStatus, false, "Creating", "requested provisioning of instance")
+			return RequeueAfter(operationPollInterval, "waiting for provisioning of instance '%s'", instance.Name)
 		}
 
 	} else {
 		log.Debugf("instance '%s' found", inst.Name)
-		// TODO: Check status of instance. Any values that could be changed for a running instance?
+
+		if inst.Status == "RUNNING" || inst.Status == "TERMINATED" {
+			if err := c.syncInstance(instance, comp, project, inst); err != nil {
+				return err
+			}
+		}
+
+		if err := c.syncRunState(instance, comp, project, inst); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -111,31 +237,213 @@ func (c *Controller) InstanceCreatedOrUpdated(instance *googlev1.Instance) error
 
 func (c *Controller) InstanceDeleted(instance *googlev1.Instance) error {
 	log.Debugf("processing deleted instance '%s/%s'", instance.Namespace, instance.Name)
-	// TODO: Use a finalizer.
 
-	var projectName string
-	if projectName = instance.Spec.Project; projectName == "" {
-		projectName = "default"
+	if !hasFinalizer(&instance.ObjectMeta) {
+		return nil
+	}
+
+	instance = instance.DeepCopy()
+
+	// A VM that is kept does not need the project, which may be gone already, e.g. while the
+	// namespace is deleted.
+	if instance.Spec.DeletionPolicy == googlev1.DeletionPolicyRetain {
+		return c.releaseInstance(instance, nil, nil, nil, googlev1.DeletionPolicyRetain)
+	}
+
+	// Use the project the instance was created in, even if the default has changed since.
+	projectName := instance.Status.Project
+	if projectName == "" {
+		projectName = instance.Spec.Project
 	}
 
-	project, err := c.ProjectLister.Projects(instance.Namespace).Get(projectName)
+	project, err := c.resolveProject(&instance.ObjectMeta, "Instance", projectName)
+	var comp *compute.Service
+	if err == nil {
+		comp, err = c.ComputeService(project)
+	}
 	if err != nil {
-		return fmt.Errorf("error getting project '%s-%s': %s", instance.Namespace, projectName, err.Error())
+		if instance.Spec.DeletionPolicy == googlev1.DeletionPolicyOrphan {
+			_ = c.MakeEvent(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not remove the management labels from the VM of instance '%s': %s", instance.Name, err.Error()), true)
+			return c.releaseInstance(instance, nil, nil, nil, googlev1.DeletionPolicyOrphan)
+		}
+		return err
 	}
 
-	comp, err := c.ComputeService(projectName, instance.Namespace)
+	if err := c.waitForInstanceOperation(instance, comp, project); err != nil {
+		return err
+	}
+
+	inst, err := comp.Instances.Get(project.Spec.Name, instanceZone(instance, project), instanceExternalName(instance)).Do()
+	if err != nil {
+		if !isNotFound(err) {
+			return fmt.Errorf("error getting instance '%s': %s", instance.Name, err.Error())
+		}
+
+		removeFinalizer(&instance.ObjectMeta)
+		if err := c.updateInstance(instance); err != nil {
+			return err
+		}
+		c.MakeEvent(&instance.ObjectMeta, "Instance", fmt.Sprintf("instance '%s' is deleted", instance.Name), false)
+		return nil
+	}
+
+	policy, err := deletionPolicy(instance.Spec.DeletionPolicy, project)
 	if err != nil {
 		return err
 	}
 
-	_, err = comp.Instances.Delete(project.Spec.Name, project.Spec.Zone, instance.Name).Do()
+	// A VM the instance never adopted is not touched.
+	if !ownsResource(&instance.ObjectMeta, &instance.Status.ResourceStatus, strconv.FormatUint(inst.Id, 10), inst.Name, instanceExternalName(instance), inst.Labels) {
+		if policy == googlev1.DeletionPolicyDelete {
+			_ = c.MakeEvent(&instance.ObjectMeta, "Instance", fmt.Sprintf("VM '%s' does not belong to instance '%s' and is kept instead of deleted", inst.Name, instance.Name), true)
+		}
+		policy = googlev1.DeletionPolicyRetain
+	}
+
+	if policy != googlev1.DeletionPolicyDelete {
+		return c.releaseInstance(instance, comp, project, inst, policy)
+	}
+
+	if inst.DeletionProtection {
+		message := fmt.Sprintf("instance '%s' has deletion protection, disable it or set the deletionPolicy to Retain", instance.Name)
+		_ = c.MakeEvent(&instance.ObjectMeta, "Instance", message, true)
+		return NewPermanentError("%s", message)
+	}
+
+	op, err := comp.Instances.Delete(project.Spec.Name, instanceZone(instance, project), instanceExternalName(instance)).Do()
 	if err != nil {
-		return c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not delete instance '%s': %s", instance.Name, err.Error()))
-	} else {
-		c.MakeEvent(&instance.ObjectMeta, "Instance", fmt.Sprintf("requested deletion of instance '%s'", instance.Name), false)
+		return keepPermanent(err, c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not delete instance '%s': %s", instance.Name, err.Error())))
+	}
+
+	setPendingOperation(&instance.ObjectMeta, op.Name)
+	if err := c.updateInstance(instance); err != nil {
+		return err
+	}
+
+	c.MakeEvent(&instance.ObjectMeta, "Instance", fmt.Sprintf("requested deletion of instance '%s'", instance.Name), false)
+	return RequeueAfter(operationPollInterval, "waiting for deletion of instance '%s'", instance.Name)
+}
+
+// releaseInstance lets go of the VM of a deleted instance without deleting it. With the
+// deletion policy Orphan, its management labels are removed first, unless inst is nil
+// because the VM could not be looked at.
+func (c *Controller) releaseInstance(instance *googlev1.Instance, comp *compute.Service, project *googleProject, inst *compute.Instance, policy googlev1.DeletionPolicy) error {
+	if policy == googlev1.DeletionPolicyOrphan && inst != nil && len(unmanagedLabels(inst.Labels)) != len(inst.Labels) {
+		_, err := comp.Instances.SetLabels(project.Spec.Name, instanceZone(instance, project), instanceExternalName(instance), &compute.InstancesSetLabelsRequest{
+			Labels:           unmanagedLabels(inst.Labels),
+			LabelFingerprint: inst.LabelFingerprint,
+		}).Do()
+		if err != nil {
+			return keepPermanent(err, fmt.Errorf("error removing labels of instance '%s': %s", instance.Name, err.Error()))
+		}
+	}
+
+	removeFinalizer(&instance.ObjectMeta)
+	if err := c.updateInstance(instance); err != nil {
+		return err
+	}
+
+	c.MakeEvent(&instance.ObjectMeta, "Instance", fmt.Sprintf("instance '%s' is deleted, the VM is kept because of the deletion policy %s", instance.Name, policy), false)
+	return nil
+}
+
+// instanceZone returns the zone the VM of an instance is in. VMs created before the zone was
+// recorded are in the zone of the project.
+func instanceZone(instance *googlev1.Instance, project *googleProject) string {
+	if instance.Status.Zone != "" {
+		return instance.Status.Zone
+	}
+	return project.Spec.Zone
+}
+
+// desiredInstanceZone returns the zone a new VM is created in, which must be in the region
+// of the project.
+func desiredInstanceZone(instance *googlev1.Instance, project *googleProject) (string, error) {
+	if instance.Spec.Zone == "" {
+		return project.Spec.Zone, nil
+	}
+	if !strings.HasPrefix(instance.Spec.Zone, project.Spec.Region+"-") {
+		return "", NewPermanentError("zone '%s' of instance '%s' is not in region '%s' of project '%s'", instance.Spec.Zone, instance.Name, project.Spec.Region, project)
+	}
+	return instance.Spec.Zone, nil
+}
+
+// setInstanceStatus records the state of the VM in the status of the instance.
+func setInstanceStatus(status *googlev1.InstanceStatus, inst *compute.Instance) {
+	status.SelfLink = inst.SelfLink
+	status.ID = strconv.FormatUint(inst.Id, 10)
+	status.ExternalName = inst.Name
+	status.State = inst.Status
+	status.Zone = path.Base(inst.Zone)
+	status.MachineType = path.Base(inst.MachineType)
+
+	status.CreationTime = nil
+	if t, err := time.Parse(time.RFC3339, inst.CreationTimestamp); err == nil {
+		created := metav1.NewTime(t)
+		status.CreationTime = &created
+	}
+
+	var internalIPs, externalIPs []string
+	for _, iface := range inst.NetworkInterfaces {
+		if iface.NetworkIP != "" {
+			internalIPs = append(internalIPs, iface.NetworkIP)
+		}
+		for _, ac := range iface.AccessConfigs {
+			if ac.NatIP != "" {
+				externalIPs = append(externalIPs, ac.NatIP)
+			}
+		}
+	}
+	status.InternalIPs = internalIPs
+	status.ExternalIPs = externalIPs
+}
+
+// waitForInstanceOperation checks on the zone operation the instance is waiting for, if
+// there is one. While it is running, a RequeueError is returned. Once it is done, it is
+// removed from the instance, and if it failed, a Warning event is emitted and an error
+// returned.
+func (c *Controller) waitForInstanceOperation(instance *googlev1.Instance, comp *compute.Service, project *googleProject) error {
+	opName := pendingOperation(&instance.ObjectMeta)
+	if opName == "" {
 		return nil
 	}
 
+	op, err := comp.ZoneOperations.Get(project.Spec.Name, instanceZone(instance, project), opName).Do()
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("error getting operation '%s' for instance '%s': %s", opName, instance.Name, err.Error())
+	}
+
+	var opType, opError string
+	if err == nil {
+		if op.Status != "DONE" {
+			return RequeueAfter(operationPollInterval, "waiting for %s of instance '%s'", op.OperationType, instance.Name)
+		}
+		opType = op.OperationType
+		opError = computeOperationError(op)
+	}
+
+	setPendingOperation(&instance.ObjectMeta, "")
+	if err := c.updateInstance(instance); err != nil {
+		return err
+	}
+
+	if opError != "" {
+		return c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("%s of instance '%s' failed: %s", opType, instance.Name, opError))
+	}
+
 	return nil
 }
 
+// updateInstance writes the metadata and spec of the instance and refreshes the object in
+// place. The status is kept, it is written separately.
+func (c *Controller) updateInstance(instance *googlev1.Instance) error {
+	updated, err := c.GoogleClient.GoogleV1().Instances(instance.Namespace).Update(instance)
+	if err != nil {
+		return fmt.Errorf("error updating instance '%s/%s': %s", instance.Namespace, instance.Name, err.Error())
+	}
+
+	status := instance.Status
+	*instance = *updated
+	instance.Status = status
+	return nil
+}
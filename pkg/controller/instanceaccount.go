@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+const scopePrefix = "https://www.googleapis.com/auth/"
+
+// instanceServiceAccounts returns the service account the VM of an instance runs as, or none.
+func instanceServiceAccounts(instance *googlev1.Instance, project *googleProject) ([]*compute.ServiceAccount, error) {
+	sa := instance.Spec.ServiceAccount
+	if sa == nil {
+		return []*compute.ServiceAccount{{
+			Email: project.Spec.ServiceAccount,
+			Scopes: []string{
+				compute.DevstorageFullControlScope,
+				compute.ComputeScope,
+			},
+		}}, nil
+	}
+
+	if sa.Email == googlev1.ServiceAccountNone {
+		if len(sa.Scopes) > 0 {
+			return nil, NewPermanentError("instance '%s' has scopes but no service account", instance.Name)
+		}
+		return nil, nil
+	}
+
+	email := sa.Email
+	if email == "" {
+		email = project.Spec.ServiceAccount
+	}
+
+	var scopes []string
+	for _, scope := range sa.Scopes {
+		if scope == "" {
+			return nil, NewPermanentError("scopes of instance '%s' contain an empty scope", instance.Name)
+		}
+		if !strings.HasPrefix(scope, "https://") {
+			scope = scopePrefix + scope
+		}
+		scopes = append(scopes, scope)
+	}
+	if len(scopes) == 0 {
+		scopes = []string{compute.CloudPlatformScope}
+	}
+
+	return []*compute.ServiceAccount{{
+		Email:  email,
+		Scopes: uniqueSorted(scopes),
+	}}, nil
+}
+
+// serviceAccountsEqual compares the service accounts of a VM with the desired ones. The
+// email "default" matches any email, Compute Engine replaces it with the email of the
+// default service account.
+func serviceAccountsEqual(current, desired []*compute.ServiceAccount) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+	for i := range desired {
+		if desired[i].Email != "default" && desired[i].Email != current[i].Email {
+			return false
+		}
+		if !stringsEqual(uniqueSorted(append([]string{}, current[i].Scopes...)), uniqueSorted(append([]string{}, desired[i].Scopes...))) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// The projects of the public images, by the prefix of their image families.
+var publicImageProjects = []struct {
+	prefix  string
+	project string
+}{
+	{"debian-", "debian-cloud"},
+	{"ubuntu-", "ubuntu-os-cloud"},
+	{"centos-", "centos-cloud"},
+	{"rocky-linux-", "rocky-linux-cloud"},
+	{"rhel-", "rhel-cloud"},
+	{"sles-", "suse-cloud"},
+	{"opensuse-", "opensuse-cloud"},
+	{"fedora-coreos-", "fedora-coreos-cloud"},
+	{"cos-", "cos-cloud"},
+	{"windows-", "windows-cloud"},
+}
+
+// instanceSourceImage returns the URL of the image to create the boot disk of a VM from. A
+// VM that is created again keeps the image it was first created from, unless the image in
+// the spec changed or the image policy is Follow.
+func (c *Controller) instanceSourceImage(instance *googlev1.Instance, comp *compute.Service) (string, error) {
+	if instance.Status.SourceImage != "" && instance.Status.Image == instance.Spec.Image && instance.Spec.ImagePolicy != googlev1.ImagePolicyFollow {
+		return instance.Status.SourceImage, nil
+	}
+
+	image, err := resolveImage(comp, instance.Spec.Image)
+	if err != nil {
+		return "", keepPermanent(err, fmt.Errorf("error resolving image of instance '%s': %s", instance.Name, err.Error()))
+	}
+	return image, nil
+}
+
+// resolveImage turns an image reference into the URL of an image. Image families, given as
+// "projects/<project>/global/images/family/<family>", "<project>/<family>" or as the family
+// of a public image alone, are resolved to their latest image.
+func resolveImage(comp *compute.Service, image string) (string, error) {
+	project, family, err := imageFamily(image)
+	if err != nil {
+		return "", err
+	}
+	if family == "" {
+		return image, nil
+	}
+
+	img, err := comp.Images.GetFromFamily(project, family).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return "", NewPermanentError("there is no image family '%s' in project '%s'", family, project)
+		}
+		return "", fmt.Errorf("error getting image family '%s' in project '%s': %s", family, project, err.Error())
+	}
+
+	return img.SelfLink, nil
+}
+
+// imageFamily returns the project and the family of an image reference, or empty strings if
+// it refers to an image directly. All errors are permanent.
+func imageFamily(image string) (project, family string, err error) {
+	parts := strings.Split(strings.TrimPrefix(image, computeAPIPrefix), "/")
+
+	switch {
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "global" && parts[3] == "images" && parts[4] == "family":
+		return parts[1], parts[5], nil
+	case strings.Contains(image, "/global/images/"):
+		return "", "", nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return parts[0], parts[1], nil
+	case len(parts) == 1 && parts[0] != "":
+		for _, p := range publicImageProjects {
+			if strings.HasPrefix(parts[0], p.prefix) {
+				return p.project, parts[0], nil
+			}
+		}
+		return "", "", NewPermanentError("'%s' is not the family of a known public image, use '<project>/%s'", parts[0], parts[0])
+	}
+	return "", "", NewPermanentError("invalid image '%s'", image)
+}
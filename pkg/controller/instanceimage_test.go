@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestImageFamily(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		project string
+		family  string
+		err     bool
+	}{
+		{"public family", "debian-12", "debian-cloud", "debian-12", false},
+		{"public family with a longer prefix", "rocky-linux-9", "rocky-linux-cloud", "rocky-linux-9", false},
+		{"unknown public family", "my-image", "", "", true},
+		{"project and family", "my-project/my-family", "my-project", "my-family", false},
+		{"family path", "projects/my-project/global/images/family/my-family", "my-project", "my-family", false},
+		{"family URL", "https://www.googleapis.com/compute/v1/projects/my-project/global/images/family/my-family", "my-project", "my-family", false},
+		{"image path", "projects/debian-cloud/global/images/debian-12-bookworm-v20240110", "", "", false},
+		{"image URL", "https://www.googleapis.com/compute/v1/projects/debian-cloud/global/images/debian-12-bookworm-v20240110", "", "", false},
+		{"empty", "", "", "", true},
+		{"empty project", "/my-family", "", "", true},
+		{"invalid path", "projects/my-project/images/my-image", "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			project, family, err := imageFamily(test.image)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got project '%s' and family '%s'", project, family)
+				}
+				if !IsPermanentError(err) {
+					t.Errorf("error '%s' is not permanent", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if project != test.project || family != test.family {
+				t.Errorf("project and family are '%s' and '%s', expected '%s' and '%s'", project, family, test.project, test.family)
+			}
+		})
+	}
+}
+
+func TestResolveImageWithoutFamily(t *testing.T) {
+	// Images that are not families are returned without asking Compute Engine.
+	for _, image := range []string{
+		"projects/debian-cloud/global/images/debian-12-bookworm-v20240110",
+		"https://www.googleapis.com/compute/v1/projects/debian-cloud/global/images/debian-12-bookworm-v20240110",
+	} {
+		resolved, err := resolveImage(nil, image)
+		if err != nil {
+			t.Errorf("unexpected error resolving '%s': %s", image, err)
+		} else if resolved != image {
+			t.Errorf("'%s' is resolved to '%s'", image, resolved)
+		}
+	}
+
+	if _, err := resolveImage(nil, "my-image"); err == nil || !IsPermanentError(err) {
+		t.Errorf("expected a permanent error for an unknown public family, got %v", err)
+	}
+}
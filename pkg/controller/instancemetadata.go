@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+const sshKeysMetadataKey = "ssh-keys"
+
+// instanceMetadata returns the metadata for the VM of an instance. Values are read from the
+// ConfigMaps and Secrets they reference, and the SSH keys are collected into "ssh-keys".
+// References that are optional and missing are left out.
+func (c *Controller) instanceMetadata(instance *googlev1.Instance) ([]*compute.MetadataItems, error) {
+	var items []*compute.MetadataItems
+	seen := map[string]bool{}
+
+	for _, item := range instance.Spec.Metadata {
+		if item.Key == "" {
+			return nil, NewPermanentError("metadata of instance '%s' has an item without a key", instance.Name)
+		}
+		if seen[item.Key] {
+			return nil, NewPermanentError("metadata of instance '%s' has the key '%s' more than once", instance.Name, item.Key)
+		}
+		seen[item.Key] = true
+
+		value, ok, err := c.metadataValue(instance.Namespace, item)
+		if err != nil {
+			return nil, keepPermanent(err, fmt.Errorf("error getting metadata '%s' of instance '%s': %s", item.Key, instance.Name, err.Error()))
+		}
+		if ok {
+			items = append(items, &compute.MetadataItems{Key: item.Key, Value: &value})
+		}
+	}
+
+	if len(instance.Spec.SSHKeys) > 0 {
+		if seen[sshKeysMetadataKey] {
+			return nil, NewPermanentError("instance '%s' sets both sshKeys and the metadata '%s'", instance.Name, sshKeysMetadataKey)
+		}
+
+		var lines []string
+		for _, key := range instance.Spec.SSHKeys {
+			if key.User == "" {
+				return nil, NewPermanentError("sshKeys of instance '%s' need a user", instance.Name)
+			}
+
+			keys, ok, err := c.secretKeyValue(instance.Namespace, &key.SecretKeyRef)
+			if err != nil {
+				return nil, fmt.Errorf("error getting ssh keys of user '%s' for instance '%s': %s", key.User, instance.Name, err.Error())
+			}
+			if !ok {
+				continue
+			}
+
+			for _, line := range strings.Split(keys, "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				lines = append(lines, key.User+":"+line)
+			}
+		}
+
+		if len(lines) > 0 {
+			value := strings.Join(lines, "\n")
+			items = append(items, &compute.MetadataItems{Key: sshKeysMetadataKey, Value: &value})
+		}
+	}
+
+	return items, nil
+}
+
+// metadataValue returns the value of a metadata item, and false if it references an optional
+// key that does not exist.
+func (c *Controller) metadataValue(namespace string, item googlev1.MetadataItem) (string, bool, error) {
+	if item.ValueFrom == nil {
+		return item.Value, true, nil
+	}
+
+	from := item.ValueFrom
+	switch {
+	case item.Value != "":
+		return "", false, NewPermanentError("value and valueFrom can not be used together")
+	case from.ConfigMapKeyRef != nil && from.SecretKeyRef != nil:
+		return "", false, NewPermanentError("valueFrom needs either configMapKeyRef or secretKeyRef, not both")
+	case from.ConfigMapKeyRef != nil:
+		return c.configMapKeyValue(namespace, from.ConfigMapKeyRef)
+	case from.SecretKeyRef != nil:
+		return c.secretKeyValue(namespace, from.SecretKeyRef)
+	}
+
+	return "", false, NewPermanentError("valueFrom needs either configMapKeyRef or secretKeyRef")
+}
+
+func (c *Controller) configMapKeyValue(namespace string, ref *corev1.ConfigMapKeySelector) (string, bool, error) {
+	optional := ref.Optional != nil && *ref.Optional
+
+	configMap, err := c.ConfigMapLister.ConfigMaps(namespace).Get(ref.Name)
+	if err != nil {
+		if errors.IsNotFound(err) && optional {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("error getting configmap '%s/%s': %s", namespace, ref.Name, err.Error())
+	}
+
+	value, ok := configMap.Data[ref.Key]
+	if !ok {
+		if optional {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("configmap '%s/%s' does not contain a key '%s'", namespace, ref.Name, ref.Key)
+	}
+
+	return value, true, nil
+}
+
+func (c *Controller) secretKeyValue(namespace string, ref *corev1.SecretKeySelector) (string, bool, error) {
+	optional := ref.Optional != nil && *ref.Optional
+
+	secret, err := c.SecretLister.Secrets(namespace).Get(ref.Name)
+	if err != nil {
+		if errors.IsNotFound(err) && optional {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("error getting secret '%s/%s': %s", namespace, ref.Name, err.Error())
+	}
+
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		if optional {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("secret '%s/%s' does not contain a key '%s'", namespace, ref.Name, ref.Key)
+	}
+
+	return string(value), true, nil
+}
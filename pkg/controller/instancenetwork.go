@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"google.golang.org/api/compute/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// Compute Engine allows at most 8 network interfaces per VM.
+const maxNetworkInterfaces = 8
+
+// instanceNetworkInterfaces returns the network interfaces for a new VM. Names of reserved
+// addresses are resolved to their IPs.
+func (c *Controller) instanceNetworkInterfaces(instance *googlev1.Instance, comp *compute.Service, project *googleProject) ([]*compute.NetworkInterface, error) {
+	specs := instance.Spec.NetworkInterfaces
+	if len(specs) == 0 {
+		specs = []googlev1.NetworkInterface{{}}
+	}
+	if len(specs) > maxNetworkInterfaces {
+		return nil, NewPermanentError("instance '%s' has %d network interfaces, at most %d are possible", instance.Name, len(specs), maxNetworkInterfaces)
+	}
+
+	var ifaces []*compute.NetworkInterface
+	for i, spec := range specs {
+		hostProject := spec.HostProject
+		if hostProject == "" {
+			hostProject = project.Spec.Name
+		}
+
+		iface := &compute.NetworkInterface{}
+		if spec.Network != "" {
+			iface.Network = fmt.Sprintf("projects/%s/global/networks/%s", hostProject, spec.Network)
+		}
+		if spec.Subnetwork != "" || spec.Network == "" {
+			subnetwork := spec.Subnetwork
+			if subnetwork == "" {
+				subnetwork = "default"
+			}
+			iface.Subnetwork = fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", hostProject, project.Spec.Region, subnetwork)
+		}
+
+		if spec.InternalIP != "" {
+			ip, err := c.resolveAddress(comp, project, spec.InternalIP)
+			if err != nil {
+				return nil, keepPermanent(err, fmt.Errorf("error getting internal IP of interface %d of instance '%s': %s", i, instance.Name, err.Error()))
+			}
+			iface.NetworkIP = ip
+		}
+
+		external := spec.ExternalIP
+		if external == "" {
+			if i == 0 {
+				external = googlev1.ExternalIPEphemeral
+			} else {
+				external = googlev1.ExternalIPNone
+			}
+		}
+
+		switch external {
+		case googlev1.ExternalIPNone:
+		case googlev1.ExternalIPEphemeral:
+			iface.AccessConfigs = []*compute.AccessConfig{{
+				Type: "ONE_TO_ONE_NAT",
+				Name: "External NAT",
+			}}
+		default:
+			ip, err := c.resolveAddress(comp, project, external)
+			if err != nil {
+				return nil, keepPermanent(err, fmt.Errorf("error getting external IP of interface %d of instance '%s': %s", i, instance.Name, err.Error()))
+			}
+			iface.AccessConfigs = []*compute.AccessConfig{{
+				Type:  "ONE_TO_ONE_NAT",
+				Name:  "External NAT",
+				NatIP: ip,
+			}}
+		}
+
+		ifaces = append(ifaces, iface)
+	}
+
+	return ifaces, nil
+}
+
+// resolveAddress returns the IP of a reserved address in the region of the project, or the
+// given string if it already is an IP.
+func (c *Controller) resolveAddress(comp *compute.Service, project *googleProject, address string) (string, error) {
+	if net.ParseIP(address) != nil {
+		return address, nil
+	}
+
+	addr, err := comp.Addresses.Get(project.Spec.Name, project.Spec.Region, address).Do()
+	if err != nil {
+		if isNotFound(err) {
+			return "", NewPermanentError("there is no address '%s' in region '%s'", address, project.Spec.Region)
+		}
+		return "", fmt.Errorf("error getting address '%s': %s", address, err.Error())
+	}
+
+	return addr.Address, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// instanceRunState returns the run state the VM of an instance should be in now, and when
+// its schedule changes it next, if it has one that applies.
+func instanceRunState(instance *googlev1.Instance, now time.Time) (googlev1.RunState, time.Time, error) {
+	switch instance.Spec.RunState {
+	case "", googlev1.RunStateRunning:
+	case googlev1.RunStateStopped, googlev1.RunStateSuspended:
+		return instance.Spec.RunState, time.Time{}, nil
+	default:
+		return "", time.Time{}, NewPermanentError("invalid runState '%s' of instance '%s'", instance.Spec.RunState, instance.Name)
+	}
+
+	if instance.Spec.Schedule == nil {
+		return googlev1.RunStateRunning, time.Time{}, nil
+	}
+
+	running, next, err := evaluateSchedule(instance.Spec.Schedule, now)
+	if err != nil {
+		return "", time.Time{}, keepPermanent(err, fmt.Errorf("error in schedule of instance '%s': %s", instance.Name, err.Error()))
+	}
+	if !running {
+		return googlev1.RunStateStopped, next, nil
+	}
+	return googlev1.RunStateRunning, next, nil
+}
+
+// syncRunState starts, stops, suspends or resumes the VM of an instance to bring it into the
+// run state of the spec, and sets the Ready condition. Without a runState or schedule in the
+// spec, a VM that was stopped by Compute Engine rather than by the controller is only started
+// again if the restart policy says so.
+func (c *Controller) syncRunState(instance *googlev1.Instance, comp *compute.Service, project *googleProject, inst *compute.Instance) error {
+	desired, next, err := instanceRunState(instance, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var schedule *googlev1.Schedule
+	if !next.IsZero() {
+		schedule = instance.Spec.Schedule
+	}
+
+	p, zone := project.Spec.Name, instanceZone(instance, project)
+
+	var op *compute.Operation
+	var action string
+
+	switch inst.Status {
+	case "PROVISIONING":
+		setReady(&instance.Status.ResourceStatus, false, "Creating", fmt.Sprintf("instance is %s", inst.Status))
+		return RequeueAfter(operationPollInterval, "waiting for provisioning of instance '%s'", instance.Name)
+	case "RUNNING":
+		switch desired {
+		case googlev1.RunStateRunning:
+			instance.Status.RunState = desired
+			setReady(&instance.Status.ResourceStatus, true, "Running", "")
+			return requeueForSchedule("instance", instance.Name, next)
+		case googlev1.RunStateStopped:
+			op, err = comp.Instances.Stop(p, zone, instanceExternalName(instance)).Do()
+			action = "stopping"
+		case googlev1.RunStateSuspended:
+			op, err = comp.Instances.Suspend(p, zone, instanceExternalName(instance)).Do()
+			action = "suspending"
+		}
+	case "TERMINATED":
+		switch desired {
+		case googlev1.RunStateStopped:
+			instance.Status.RunState = desired
+			setReady(&instance.Status.ResourceStatus, true, "Stopped", "")
+			return requeueForSchedule("instance", instance.Name, next)
+		case googlev1.RunStateRunning:
+			if instance.Spec.RunState == "" && instance.Spec.Schedule == nil && (instance.Status.RunState == "" || instance.Status.RunState == googlev1.RunStateRunning) {
+				setReady(&instance.Status.ResourceStatus, false, "NotRunning", fmt.Sprintf("instance is %s", inst.Status))
+				if err := c.restartInstance(instance, comp, project); err != nil {
+					return err
+				}
+				return requeueForSchedule("instance", instance.Name, next)
+			}
+			op, err = comp.Instances.Start(p, zone, instanceExternalName(instance)).Do()
+			action = "starting"
+		case googlev1.RunStateSuspended:
+			// Only running VMs can be suspended, so it is started first.
+			op, err = comp.Instances.Start(p, zone, instanceExternalName(instance)).Do()
+			action = "starting"
+		}
+	case "SUSPENDED":
+		switch desired {
+		case googlev1.RunStateSuspended:
+			instance.Status.RunState = desired
+			setReady(&instance.Status.ResourceStatus, true, "Suspended", "")
+			return requeueForSchedule("instance", instance.Name, next)
+		case googlev1.RunStateRunning:
+			op, err = comp.Instances.Resume(p, zone, instanceExternalName(instance)).Do()
+			action = "resuming"
+		case googlev1.RunStateStopped:
+			op, err = comp.Instances.Stop(p, zone, instanceExternalName(instance)).Do()
+			action = "stopping"
+		}
+	default:
+		setReady(&instance.Status.ResourceStatus, false, "NotRunning", fmt.Sprintf("instance is %s", inst.Status))
+		return RequeueAfter(operationPollInterval, "waiting for instance '%s', which is %s", instance.Name, inst.Status)
+	}
+
+	if err != nil {
+		return keepPermanent(err, c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("error %s instance '%s': %s", action, instance.Name, err.Error())))
+	}
+
+	instance.Status.RunState = desired
+	setReady(&instance.Status.ResourceStatus, false, "Changing", fmt.Sprintf("%s instance, which is %s", action, inst.Status))
+	return c.startRunStateOperation(instance, op, scheduledMessage(fmt.Sprintf("%s instance '%s' to make it %s", action, instance.Name, desired), schedule))
+}
+
+// startRunStateOperation records the operation that changes the run state of the VM and asks
+// for the instance to be processed again when it is expected to be done.
+func (c *Controller) startRunStateOperation(instance *googlev1.Instance, op *compute.Operation, message string) error {
+	setPendingOperation(&instance.ObjectMeta, op.Name)
+	if err := c.updateInstance(instance); err != nil {
+		return err
+	}
+
+	c.MakeEvent(&instance.ObjectMeta, "Instance", message, false)
+	return RequeueAfter(operationPollInterval, "%s", message)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/api/compute/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// instanceScheduling returns the scheduling options for the VM of an instance, or nil if the
+// instance does not set any and the defaults of Compute Engine apply.
+func instanceScheduling(instance *googlev1.Instance) (*compute.Scheduling, error) {
+	spec := instance.Spec.Scheduling
+	if spec == nil {
+		return nil, nil
+	}
+
+	s := &compute.Scheduling{}
+	interruptible := false
+
+	switch spec.ProvisioningModel {
+	case "", googlev1.ProvisioningModelStandard:
+		s.ProvisioningModel = "STANDARD"
+	case googlev1.ProvisioningModelSpot:
+		s.ProvisioningModel = "SPOT"
+		interruptible = true
+	case googlev1.ProvisioningModelPreemptible:
+		s.Preemptible = true
+		interruptible = true
+	default:
+		return nil, NewPermanentError("invalid provisioningModel '%s' of instance '%s'", spec.ProvisioningModel, instance.Name)
+	}
+
+	switch spec.OnHostMaintenance {
+	case "":
+		s.OnHostMaintenance = "MIGRATE"
+		if interruptible {
+			s.OnHostMaintenance = "TERMINATE"
+		}
+	case "MIGRATE":
+		if interruptible {
+			return nil, NewPermanentError("%s instance '%s' can not be migrated on host maintenance", spec.ProvisioningModel, instance.Name)
+		}
+		s.OnHostMaintenance = spec.OnHostMaintenance
+	case "TERMINATE":
+		s.OnHostMaintenance = spec.OnHostMaintenance
+	default:
+		return nil, NewPermanentError("invalid onHostMaintenance '%s' of instance '%s'", spec.OnHostMaintenance, instance.Name)
+	}
+
+	automaticRestart := !interruptible
+	if spec.AutomaticRestart != nil {
+		if *spec.AutomaticRestart && interruptible {
+			return nil, NewPermanentError("%s instance '%s' can not be restarted automatically", spec.ProvisioningModel, instance.Name)
+		}
+		automaticRestart = *spec.AutomaticRestart
+	}
+	s.AutomaticRestart = &automaticRestart
+	s.ForceSendFields = []string{"AutomaticRestart"}
+
+	if spec.MaxRunDuration != nil {
+		seconds := int64(spec.MaxRunDuration.Seconds())
+		if seconds <= 0 {
+			return nil, NewPermanentError("maxRunDuration of instance '%s' must be positive", instance.Name)
+		}
+		s.MaxRunDuration = &compute.Duration{Seconds: seconds}
+	}
+
+	switch spec.InstanceTerminationAction {
+	case "":
+		if s.ProvisioningModel == "SPOT" || s.MaxRunDuration != nil {
+			s.InstanceTerminationAction = "STOP"
+		}
+	case "STOP", "DELETE":
+		if s.ProvisioningModel != "SPOT" && s.MaxRunDuration == nil {
+			return nil, NewPermanentError("instanceTerminationAction of instance '%s' needs a Spot VM or a maxRunDuration", instance.Name)
+		}
+		s.InstanceTerminationAction = spec.InstanceTerminationAction
+	default:
+		return nil, NewPermanentError("invalid instanceTerminationAction '%s' of instance '%s'", spec.InstanceTerminationAction, instance.Name)
+	}
+
+	switch spec.RestartPolicy {
+	case "", googlev1.RestartPolicyAlways, googlev1.RestartPolicyNever:
+	default:
+		return nil, NewPermanentError("invalid restartPolicy '%s' of instance '%s'", spec.RestartPolicy, instance.Name)
+	}
+
+	return s, nil
+}
+
+// schedulingEqual compares the scheduling options of a VM with the desired ones. Without
+// desired options, any are fine.
+func schedulingEqual(current, desired *compute.Scheduling) bool {
+	if desired == nil {
+		return true
+	}
+	if current == nil {
+		current = &compute.Scheduling{}
+	}
+
+	currentModel := current.ProvisioningModel
+	if currentModel == "" {
+		currentModel = "STANDARD"
+	}
+	if desired.ProvisioningModel != "" && desired.ProvisioningModel != currentModel {
+		return false
+	}
+
+	if current.Preemptible != desired.Preemptible || current.OnHostMaintenance != desired.OnHostMaintenance {
+		return false
+	}
+
+	// Compute Engine restarts VMs automatically unless told otherwise.
+	currentRestart := current.AutomaticRestart == nil || *current.AutomaticRestart
+	if currentRestart != *desired.AutomaticRestart {
+		return false
+	}
+
+	if desired.InstanceTerminationAction != "" && desired.InstanceTerminationAction != current.InstanceTerminationAction {
+		return false
+	}
+
+	var currentSeconds, desiredSeconds int64
+	if current.MaxRunDuration != nil {
+		currentSeconds = current.MaxRunDuration.Seconds
+	}
+	if desired.MaxRunDuration != nil {
+		desiredSeconds = desired.MaxRunDuration.Seconds
+	}
+	return currentSeconds == desiredSeconds
+}
+
+// restartInstance starts the VM of an instance again if it was stopped by Compute Engine and
+// the restart policy asks for it.
+func (c *Controller) restartInstance(instance *googlev1.Instance, comp *compute.Service, project *googleProject) error {
+	if instance.Spec.Scheduling == nil || instance.Spec.Scheduling.RestartPolicy != googlev1.RestartPolicyAlways {
+		return nil
+	}
+
+	op, err := comp.Instances.Start(project.Spec.Name, instanceZone(instance, project), instanceExternalName(instance)).Do()
+	if err != nil {
+		return keepPermanent(err, c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not restart instance '%s': %s", instance.Name, err.Error())))
+	}
+
+	setReady(&instance.Status.ResourceStatus, false, "Restarting", "instance was stopped by Compute Engine")
+	return c.startRunStateOperation(instance, op, fmt.Sprintf("instance '%s' was stopped by Compute Engine, restarting it", instance.Name))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+func TestInstanceScheduling(t *testing.T) {
+	yes, no := true, false
+	hours := func(h int) *metav1.Duration {
+		return &metav1.Duration{Duration: time.Duration(h) * time.Hour}
+	}
+
+	tests := []struct {
+		name       string
+		scheduling *googlev1.Scheduling
+		expected   *compute.Scheduling
+	}{
+		{"defaults of Compute Engine", nil, nil},
+		{"standard", &googlev1.Scheduling{}, &compute.Scheduling{
+			ProvisioningModel: "STANDARD",
+			OnHostMaintenance: "MIGRATE",
+			AutomaticRestart:  &yes,
+		}},
+		{"standard without restart", &googlev1.Scheduling{OnHostMaintenance: "TERMINATE", AutomaticRestart: &no}, &compute.Scheduling{
+			ProvisioningModel: "STANDARD",
+			OnHostMaintenance: "TERMINATE",
+			AutomaticRestart:  &no,
+		}},
+		{"standard with a maximum run duration", &googlev1.Scheduling{MaxRunDuration: hours(4)}, &compute.Scheduling{
+			ProvisioningModel:         "STANDARD",
+			OnHostMaintenance:         "MIGRATE",
+			AutomaticRestart:          &yes,
+			MaxRunDuration:            &compute.Duration{Seconds: 4 * 3600},
+			InstanceTerminationAction: "STOP",
+		}},
+		{"spot", &googlev1.Scheduling{ProvisioningModel: googlev1.ProvisioningModelSpot}, &compute.Scheduling{
+			ProvisioningModel:         "SPOT",
+			OnHostMaintenance:         "TERMINATE",
+			AutomaticRestart:          &no,
+			InstanceTerminationAction: "STOP",
+		}},
+		{"spot that is deleted", &googlev1.Scheduling{ProvisioningModel: googlev1.ProvisioningModelSpot, InstanceTerminationAction: "DELETE"}, &compute.Scheduling{
+			ProvisioningModel:         "SPOT",
+			OnHostMaintenance:         "TERMINATE",
+			AutomaticRestart:          &no,
+			InstanceTerminationAction: "DELETE",
+		}},
+		{"preemptible", &googlev1.Scheduling{ProvisioningModel: googlev1.ProvisioningModelPreemptible, RestartPolicy: googlev1.RestartPolicyAlways}, &compute.Scheduling{
+			Preemptible:       true,
+			OnHostMaintenance: "TERMINATE",
+			AutomaticRestart:  &no,
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &googlev1.Instance{
+				ObjectMeta: metav1.ObjectMeta{Name: "vm"},
+				Spec:       googlev1.InstanceSpec{Scheduling: test.scheduling},
+			}
+
+			scheduling, err := instanceScheduling(instance)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if test.expected == nil {
+				if scheduling != nil {
+					t.Errorf("expected no scheduling, got %+v", scheduling)
+				}
+				return
+			}
+			if scheduling == nil {
+				t.Fatal("expected scheduling, got none")
+			}
+
+			if scheduling.ProvisioningModel != test.expected.ProvisioningModel || scheduling.Preemptible != test.expected.Preemptible {
+				t.Errorf("provisioning model is '%s' and preemptible %t, expected '%s' and %t", scheduling.ProvisioningModel, scheduling.Preemptible, test.expected.ProvisioningModel, test.expected.Preemptible)
+			}
+			if scheduling.OnHostMaintenance != test.expected.OnHostMaintenance {
+				t.Errorf("onHostMaintenance is '%s', expected '%s'", scheduling.OnHostMaintenance, test.expected.OnHostMaintenance)
+			}
+			if scheduling.AutomaticRestart == nil || *scheduling.AutomaticRestart != *test.expected.AutomaticRestart {
+				t.Errorf("automaticRestart is %v, expected %t", scheduling.AutomaticRestart, *test.expected.AutomaticRestart)
+			}
+			if scheduling.InstanceTerminationAction != test.expected.InstanceTerminationAction {
+				t.Errorf("instanceTerminationAction is '%s', expected '%s'", scheduling.InstanceTerminationAction, test.expected.InstanceTerminationAction)
+			}
+			if (scheduling.MaxRunDuration == nil) != (test.expected.MaxRunDuration == nil) ||
+				(scheduling.MaxRunDuration != nil && scheduling.MaxRunDuration.Seconds != test.expected.MaxRunDuration.Seconds) {
+				t.Errorf("maxRunDuration is %+v, expected %+v", scheduling.MaxRunDuration, test.expected.MaxRunDuration)
+			}
+			if !schedulingEqual(test.expected, scheduling) {
+				t.Error("scheduling is not equal to the expected one")
+			}
+		})
+	}
+}
+
+func TestInstanceSchedulingErrors(t *testing.T) {
+	yes := true
+
+	tests := []struct {
+		name       string
+		scheduling googlev1.Scheduling
+	}{
+		{"invalid provisioning model", googlev1.Scheduling{ProvisioningModel: "Cheap"}},
+		{"spot that is migrated", googlev1.Scheduling{ProvisioningModel: googlev1.ProvisioningModelSpot, OnHostMaintenance: "MIGRATE"}},
+		{"preemptible that is restarted", googlev1.Scheduling{ProvisioningModel: googlev1.ProvisioningModelPreemptible, AutomaticRestart: &yes}},
+		{"invalid onHostMaintenance", googlev1.Scheduling{OnHostMaintenance: "RESTART"}},
+		{"maximum run duration that is not positive", googlev1.Scheduling{MaxRunDuration: &metav1.Duration{}}},
+		{"termination action of a standard VM", googlev1.Scheduling{InstanceTerminationAction: "STOP"}},
+		{"invalid termination action", googlev1.Scheduling{ProvisioningModel: googlev1.ProvisioningModelSpot, InstanceTerminationAction: "SUSPEND"}},
+		{"invalid restart policy", googlev1.Scheduling{RestartPolicy: "Sometimes"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			instance := &googlev1.Instance{
+				ObjectMeta: metav1.ObjectMeta{Name: "vm"},
+				Spec:       googlev1.InstanceSpec{Scheduling: &test.scheduling},
+			}
+
+			scheduling, err := instanceScheduling(instance)
+			if err == nil {
+				t.Fatalf("expected an error, got %+v", scheduling)
+			}
+			if !IsPermanentError(err) {
+				t.Errorf("error '%s' is not permanent", err)
+			}
+		})
+	}
+}
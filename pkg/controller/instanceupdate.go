@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// Set on an instance while its VM is stopped to change the machine type, so the controller
+// knows to start it again afterwards.
+const stoppedForUpdateAnnotation = "google.cloudcrd.weisnix.org/stopped-for-update"
+
+func metadataEqual(a, b []*compute.MetadataItems) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	values := make(map[string]string, len(a))
+	for _, item := range a {
+		if item.Value != nil {
+			values[item.Key] = *item.Value
+		} else {
+			values[item.Key] = ""
+		}
+	}
+	for _, item := range b {
+		value, ok := values[item.Key]
+		if !ok || (item.Value == nil && value != "") || (item.Value != nil && *item.Value != value) {
+			return false
+		}
+	}
+	return true
+}
+
+func labelsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// syncInstance compares the spec of the instance with its VM and starts an operation to
+// apply the first difference it finds. Changes that need the VM to be stopped are applied by
+// stopping it, changing it and starting it again. Changes of the image or zone recreate the
+// VM with the update policy Recreate, otherwise they are reported while the other changes
+// are still applied. While an operation is running, a RequeueError is returned.
+func (c *Controller) syncInstance(instance *googlev1.Instance, comp *compute.Service, project *googleProject, inst *compute.Instance) error {
+	spec := &instance.Spec
+	p, zone := project.Spec.Name, instanceZone(instance, project)
+
+	// Instances created before the image was recorded are assumed to be up to date.
+	if instance.Status.Image == "" {
+		instance.Status.Image = spec.Image
+	}
+	if instance.Status.SourceImage == "" {
+		for _, d := range inst.Disks {
+			if !d.Boot {
+				continue
+			}
+			disk, err := comp.Disks.Get(p, zone, path.Base(d.Source)).Do()
+			if err != nil {
+				return fmt.Errorf("error getting boot disk of instance '%s': %s", instance.Name, err.Error())
+			}
+			instance.Status.SourceImage = disk.SourceImage
+		}
+	}
+
+	var changes []string
+	if spec.Image != instance.Status.Image {
+		changes = append(changes, fmt.Sprintf("image of instance '%s' changed from '%s' to '%s'", instance.Name, instance.Status.Image, spec.Image))
+	}
+	if spec.Zone != "" && spec.Zone != zone {
+		if _, err := desiredInstanceZone(instance, project); err != nil {
+			return err
+		}
+		changes = append(changes, fmt.Sprintf("zone of instance '%s' changed from '%s' to '%s'", instance.Name, zone, spec.Zone))
+	}
+
+	if len(changes) > 0 && spec.UpdatePolicy == googlev1.UpdatePolicyRecreate {
+		message := strings.Join(changes, ", ")
+		op, err := comp.Instances.Delete(p, zone, instanceExternalName(instance)).Do()
+		if err != nil {
+			return keepPermanent(err, c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not delete instance '%s' to recreate it: %s", instance.Name, err.Error())))
+		}
+		setReady(&instance.Status.ResourceStatus, false, "Recreating", message)
+		return c.startInstanceOperation(instance, op, message+", recreating it")
+	}
+
+	// Changes that can not be applied at all, whatever the update policy.
+	var unapplicable []string
+
+	// Otherwise the changes that need the VM to be recreated are only reported, like those
+	// that can not be applied, once the others have been applied.
+	upToDate := func() {
+		var messages []string
+		reason := "NotApplicable"
+		if len(changes) > 0 && spec.UpdatePolicy != googlev1.UpdatePolicyIgnore {
+			messages = append(messages, strings.Join(changes, ", ")+", which needs the instance to be recreated")
+			reason = "ImmutableChange"
+		}
+		messages = append(messages, unapplicable...)
+
+		switch {
+		case len(messages) > 0:
+			c.instanceNotUpToDate(instance, reason, strings.Join(messages, "; "))
+		case len(changes) > 0:
+			setCondition(&instance.Status.ResourceStatus, googlev1.ConditionUpToDate, corev1.ConditionFalse, "Ignored", strings.Join(changes, ", "))
+		default:
+			setCondition(&instance.Status.ResourceStatus, googlev1.ConditionUpToDate, corev1.ConditionTrue, "", "")
+		}
+	}
+
+	labels, err := managedLabels(&instance.ObjectMeta, spec.Labels)
+	if err != nil {
+		return err
+	}
+	if !labelsEqual(inst.Labels, labels) {
+		op, err := comp.Instances.SetLabels(p, zone, instanceExternalName(instance), &compute.InstancesSetLabelsRequest{
+			Labels:           labels,
+			LabelFingerprint: inst.LabelFingerprint,
+		}).Do()
+		if err != nil {
+			return keepPermanent(err, fmt.Errorf("error setting labels of instance '%s': %s", instance.Name, err.Error()))
+		}
+		return c.startInstanceOperation(instance, op, fmt.Sprintf("updating labels of instance '%s'", instance.Name))
+	}
+
+	if inst.DeletionProtection != spec.DeletionProtection {
+		op, err := comp.Instances.SetDeletionProtection(p, zone, instanceExternalName(instance)).DeletionProtection(spec.DeletionProtection).Do()
+		if err != nil {
+			return keepPermanent(err, fmt.Errorf("error setting deletion protection of instance '%s': %s", instance.Name, err.Error()))
+		}
+		return c.startInstanceOperation(instance, op, fmt.Sprintf("setting deletion protection of instance '%s' to %t", instance.Name, spec.DeletionProtection))
+	}
+
+	var currentTags []string
+	var tagsFingerprint string
+	if inst.Tags != nil {
+		currentTags = inst.Tags.Items
+		tagsFingerprint = inst.Tags.Fingerprint
+	}
+	if desired := uniqueSorted(append([]string{}, spec.Tags...)); !stringsEqual(uniqueSorted(currentTags), desired) {
+		op, err := comp.Instances.SetTags(p, zone, instanceExternalName(instance), &compute.Tags{
+			Items:       desired,
+			Fingerprint: tagsFingerprint,
+		}).Do()
+		if err != nil {
+			return keepPermanent(err, fmt.Errorf("error setting tags of instance '%s': %s", instance.Name, err.Error()))
+		}
+		return c.startInstanceOperation(instance, op, fmt.Sprintf("updating tags of instance '%s'", instance.Name))
+	}
+
+	var currentMetadata []*compute.MetadataItems
+	var metadataFingerprint string
+	if inst.Metadata != nil {
+		currentMetadata = inst.Metadata.Items
+		metadataFingerprint = inst.Metadata.Fingerprint
+	}
+	desiredMetadata, err := c.instanceMetadata(instance)
+	if err != nil {
+		return err
+	}
+	if !metadataEqual(currentMetadata, desiredMetadata) {
+		op, err := comp.Instances.SetMetadata(p, zone, instanceExternalName(instance), &compute.Metadata{
+			Items:       desiredMetadata,
+			Fingerprint: metadataFingerprint,
+		}).Do()
+		if err != nil {
+			return keepPermanent(err, fmt.Errorf("error setting metadata of instance '%s': %s", instance.Name, err.Error()))
+		}
+		return c.startInstanceOperation(instance, op, fmt.Sprintf("updating metadata of instance '%s'", instance.Name))
+	}
+
+	if spec.DiskSize > 0 {
+		for _, d := range inst.Disks {
+			if !d.Boot {
+				continue
+			}
+
+			diskName := path.Base(d.Source)
+			disk, err := comp.Disks.Get(p, zone, diskName).Do()
+			if err != nil {
+				return fmt.Errorf("error getting boot disk '%s' of instance '%s': %s", diskName, instance.Name, err.Error())
+			}
+
+			if spec.DiskSize < disk.SizeGb {
+				unapplicable = append(unapplicable, fmt.Sprintf("boot disk of instance '%s' is %d GB and can not be shrunk to %d GB", instance.Name, disk.SizeGb, spec.DiskSize))
+			}
+
+			if spec.DiskSize > disk.SizeGb {
+				op, err := comp.Disks.Resize(p, zone, diskName, &compute.DisksResizeRequest{SizeGb: spec.DiskSize}).Do()
+				if err != nil {
+					return keepPermanent(err, fmt.Errorf("error resizing boot disk of instance '%s': %s", instance.Name, err.Error()))
+				}
+				return c.startInstanceOperation(instance, op, fmt.Sprintf("resizing boot disk of instance '%s' from %d to %d GB", instance.Name, disk.SizeGb, spec.DiskSize))
+			}
+		}
+	}
+
+	serviceAccounts, err := instanceServiceAccounts(instance, project)
+	if err != nil {
+		return err
+	}
+
+	scheduling, err := instanceScheduling(instance)
+	if err != nil {
+		return err
+	}
+
+	// The machine type, the service account and the scheduling can only be changed while the VM is stopped.
+	var stopFor []string
+	machineTypeChanged := path.Base(inst.MachineType) != spec.Type
+	if machineTypeChanged {
+		stopFor = append(stopFor, "change the machine type to "+spec.Type)
+	}
+	serviceAccountChanged := !serviceAccountsEqual(inst.ServiceAccounts, serviceAccounts)
+	if serviceAccountChanged {
+		stopFor = append(stopFor, "change the service account")
+	}
+	schedulingChanged := !schedulingEqual(inst.Scheduling, scheduling)
+	if schedulingChanged {
+		stopFor = append(stopFor, "change the scheduling")
+	}
+
+	if len(stopFor) > 0 {
+		switch inst.Status {
+		case "RUNNING":
+			op, err := comp.Instances.Stop(p, zone, instanceExternalName(instance)).Do()
+			if err != nil {
+				return fmt.Errorf("error stopping instance '%s': %s", instance.Name, err.Error())
+			}
+			if instance.Annotations == nil {
+				instance.Annotations = map[string]string{}
+			}
+			instance.Annotations[stoppedForUpdateAnnotation] = "true"
+			return c.startInstanceOperation(instance, op, fmt.Sprintf("stopping instance '%s' to %s", instance.Name, strings.Join(stopFor, " and ")))
+		case "TERMINATED":
+			if machineTypeChanged {
+				op, err := comp.Instances.SetMachineType(p, zone, instanceExternalName(instance), &compute.InstancesSetMachineTypeRequest{
+					MachineType: fmt.Sprintf("zones/%s/machineTypes/%s", zone, spec.Type),
+				}).Do()
+				if err != nil {
+					return keepPermanent(err, c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not change the machine type of instance '%s': %s", instance.Name, err.Error())))
+				}
+				return c.startInstanceOperation(instance, op, fmt.Sprintf("changing the machine type of instance '%s' from %s to %s", instance.Name, path.Base(inst.MachineType), spec.Type))
+			}
+
+			if schedulingChanged {
+				op, err := comp.Instances.SetScheduling(p, zone, instanceExternalName(instance), scheduling).Do()
+				if err != nil {
+					return keepPermanent(err, c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not change the scheduling of instance '%s': %s", instance.Name, err.Error())))
+				}
+				return c.startInstanceOperation(instance, op, fmt.Sprintf("changing the scheduling of instance '%s'", instance.Name))
+			}
+
+			// Without an email and scopes, the service account is removed from the VM.
+			req := &compute.InstancesSetServiceAccountRequest{ForceSendFields: []string{"Email", "Scopes"}}
+			if len(serviceAccounts) > 0 {
+				req.Email = serviceAccounts[0].Email
+				req.Scopes = serviceAccounts[0].Scopes
+			}
+			op, err := comp.Instances.SetServiceAccount(p, zone, instanceExternalName(instance), req).Do()
+			if err != nil {
+				return keepPermanent(err, c.MakeEventAndFail(&instance.ObjectMeta, "Instance", fmt.Sprintf("could not change the service account of instance '%s': %s", instance.Name, err.Error())))
+			}
+			return c.startInstanceOperation(instance, op, fmt.Sprintf("changing the service account of instance '%s'", instance.Name))
+		}
+		return RequeueAfter(operationPollInterval, "waiting for instance '%s' to stop or start", instance.Name)
+	}
+
+	if instance.Annotations[stoppedForUpdateAnnotation] != "" && inst.Status == "TERMINATED" {
+		// The VM is left stopped if it is meant to be by now.
+		if runState, _, err := instanceRunState(instance, time.Now()); err == nil && runState == googlev1.RunStateStopped {
+			delete(instance.Annotations, stoppedForUpdateAnnotation)
+			if err := c.updateInstance(instance); err != nil {
+				return err
+			}
+			upToDate()
+			return nil
+		}
+
+		op, err := comp.Instances.Start(p, zone, instanceExternalName(instance)).Do()
+		if err != nil {
+			return fmt.Errorf("error starting instance '%s': %s", instance.Name, err.Error())
+		}
+		delete(instance.Annotations, stoppedForUpdateAnnotation)
+		return c.startInstanceOperation(instance, op, fmt.Sprintf("starting instance '%s' after the update", instance.Name))
+	}
+
+	upToDate()
+	return nil
+}
+
+// startInstanceOperation records the operation on the instance and asks for it to be
+// processed again when the operation is expected to be done.
+func (c *Controller) startInstanceOperation(instance *googlev1.Instance, op *compute.Operation, message string) error {
+	setPendingOperation(&instance.ObjectMeta, op.Name)
+	if err := c.updateInstance(instance); err != nil {
+		return err
+	}
+
+	setCondition(&instance.Status.ResourceStatus, googlev1.ConditionUpToDate, corev1.ConditionFalse, "Updating", message)
+
+	c.MakeEvent(&instance.ObjectMeta, "Instance", message, false)
+	return RequeueAfter(operationPollInterval, "%s", message)
+}
+
+// instanceNotUpToDate sets the UpToDate condition to false and emits a Warning event, unless
+// the condition already says the same.
+func (c *Controller) instanceNotUpToDate(instance *googlev1.Instance, reason, message string) {
+	cond := getCondition(&instance.Status.ResourceStatus, googlev1.ConditionUpToDate)
+	if cond == nil || cond.Status != corev1.ConditionFalse || cond.Message != message {
+		_ = c.MakeEvent(&instance.ObjectMeta, "Instance", message, true)
+	}
+	setCondition(&instance.Status.ResourceStatus, googlev1.ConditionUpToDate, corev1.ConditionFalse, reason, message)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// The labels the controller puts on the resources it manages in Google Cloud, to tie them to
+// their objects.
+const (
+	managementLabelPrefix = "cloudcrd-"
+	labelManagedBy        = managementLabelPrefix + "managed-by"
+	labelNamespace        = managementLabelPrefix + "namespace"
+	labelName             = managementLabelPrefix + "name"
+
+	managedByValue = "kube-cloud-crd-google"
+)
+
+// Label values in Google Cloud may only use lowercase letters, digits, underscores and
+// dashes, and are at most 63 characters long.
+var invalidLabelValueChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+const maxLabelValueLength = 63
+
+// managedLabels returns the labels of a resource, those from its spec and the management
+// labels of its object. The spec may not set management labels itself.
+func managedLabels(meta *metav1.ObjectMeta, labels map[string]string) (map[string]string, error) {
+	result := make(map[string]string, len(labels)+3)
+	for k, v := range labels {
+		if strings.HasPrefix(k, managementLabelPrefix) {
+			return nil, NewPermanentError("label '%s' of '%s' is reserved for the controller", k, meta.Name)
+		}
+		result[k] = v
+	}
+
+	result[labelManagedBy] = managedByValue
+	result[labelNamespace] = labelValue(meta.Namespace)
+	result[labelName] = labelValue(meta.Name)
+	return result, nil
+}
+
+// labelValue returns s as a valid label value. If s is not one already, the invalid
+// characters are replaced, it is shortened and a hash of s is appended, so that different
+// values stay different.
+func labelValue(s string) string {
+	if len(s) <= maxLabelValueLength && !invalidLabelValueChars.MatchString(s) {
+		return s
+	}
+
+	sum := sha256.Sum256([]byte(s))
+	hash := hex.EncodeToString(sum[:])[:8]
+
+	value := invalidLabelValueChars.ReplaceAllString(strings.ToLower(s), "_")
+	if max := maxLabelValueLength - len(hash) - 1; len(value) > max {
+		value = value[:max]
+	}
+	return value + "-" + hash
+}
+
+// unmanagedLabels returns the labels without the management labels.
+func unmanagedLabels(labels map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range labels {
+		if !strings.HasPrefix(k, managementLabelPrefix) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// hasManagementLabels reports whether the labels tie a resource to the object.
+func hasManagementLabels(meta *metav1.ObjectMeta, labels map[string]string) bool {
+	return labels[labelManagedBy] == managedByValue && labels[labelNamespace] == labelValue(meta.Namespace) && labels[labelName] == labelValue(meta.Name)
+}
+
+// deletionPolicy returns the deletion policy of an object, falling back to the one of its
+// project.
+func deletionPolicy(policy googlev1.DeletionPolicy, project *googleProject) (googlev1.DeletionPolicy, error) {
+	if policy == "" {
+		policy = project.Spec.DeletionPolicy
+	}
+
+	switch policy {
+	case "":
+		return googlev1.DeletionPolicyDelete, nil
+	case googlev1.DeletionPolicyDelete, googlev1.DeletionPolicyRetain, googlev1.DeletionPolicyOrphan:
+		return policy, nil
+	}
+	return "", NewPermanentError("invalid deletionPolicy '%s', must be Delete, Retain or Orphan", policy)
+}
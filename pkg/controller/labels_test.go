@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		label string
+	}{
+		{"empty", "", ""},
+		{"valid", "my-app_1", "my-app_1"},
+		{"longest valid", strings.Repeat("a", 63), strings.Repeat("a", 63)},
+		{"uppercase", "Default", "default-21b111cb"},
+		{"invalid characters", "my.app", "my_app-13d7e337"},
+		{"spaces and slashes", "Team A/B", "team_a_b-7a43b660"},
+		{"too long", strings.Repeat("a", 70), strings.Repeat("a", 54) + "-6bd5e503"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			label := labelValue(test.value)
+			if label != test.label {
+				t.Errorf("label value of '%s' is '%s', expected '%s'", test.value, label, test.label)
+			}
+			if len(label) > maxLabelValueLength || invalidLabelValueChars.MatchString(label) {
+				t.Errorf("label value '%s' is not valid", label)
+			}
+		})
+	}
+}
+
+func TestLabelValueKeepsValuesApart(t *testing.T) {
+	values := []string{"my.app", "my_app", "my/app", strings.Repeat("a", 70), strings.Repeat("a", 71)}
+
+	seen := map[string]string{}
+	for _, value := range values {
+		label := labelValue(value)
+		if other, ok := seen[label]; ok {
+			t.Errorf("'%s' and '%s' both have the label value '%s'", other, value, label)
+		}
+		seen[label] = value
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/compute/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -30,7 +31,16 @@ func main() {
 		kubeconfig = e
 	}
 
+	var maxRetries int
+	var credentialsNamespace string
+	var defaultProject string
+	var expiryWarning time.Duration
+
 	flag.StringVar(&kubeconfig, "kubeconfig", os.Getenv("HOME")+"/.kube/config", "location of your kubeconfig")
+	flag.IntVar(&maxRetries, "max-retries", 15, "number of retries with backoff before giving up on a failing object")
+	flag.StringVar(&defaultProject, "default-project", "default", "project used by objects without spec.project, if their namespace has no default")
+	flag.StringVar(&credentialsNamespace, "credentials-namespace", "kube-cloud-crd-google", "namespace the Secrets of ClusterProjects are read from")
+	flag.DurationVar(&expiryWarning, "expiry-warning", time.Hour, "how long before an object expires a warning event is emitted")
 	flag.Parse()
 
 	clientConfig, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
@@ -48,12 +58,15 @@ func main() {
 		panic(err.Error())
 	}
 
-	c := &Controller{Kubernetes: clientset, GoogleClient: google}
+	c := &Controller{Kubernetes: clientset, GoogleClient: google, MaxRetries: maxRetries, CredentialsNamespace: credentialsNamespace, DefaultProject: defaultProject, ExpiryWarning: expiryWarning}
 
 	c.Initialize()
+	c.InitializeDependencies()
 	c.Start()
 }
 
+// MakeEvent emits an event about an object. The same event is emitted at most once per
+// eventRepeatInterval, as failing objects are processed again on every resync.
 func (c *Controller) MakeEvent(meta *metav1.ObjectMeta, kind string, message string, warn bool) error {
 	var t string
 	if warn {
@@ -62,6 +75,11 @@ func (c *Controller) MakeEvent(meta *metav1.ObjectMeta, kind string, message str
 		t = "Normal"
 	}
 
+	if !c.events.shouldEmit(string(meta.UID)+"/"+t+"/"+message, time.Now()) {
+		log.Debugf("not repeating event about %s '%s': %s", kind, meta.Name, message)
+		return nil
+	}
+
 	event := &corev1.Event{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: meta.Name,
@@ -80,7 +98,13 @@ func (c *Controller) MakeEvent(meta *metav1.ObjectMeta, kind string, message str
 		Type:           t,
 	}
 
-	_, err := c.Kubernetes.CoreV1().Events(meta.Namespace).Create(event)
+	// Events about cluster scoped objects go to the default namespace.
+	namespace := meta.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	_, err := c.Kubernetes.CoreV1().Events(namespace).Create(event)
 	return err
 }
 
@@ -90,47 +114,75 @@ func (c *Controller) MakeEventAndFail(meta *metav1.ObjectMeta, kind string, mess
 	return fmt.Errorf(message)
 }
 
-func (c *Controller) ComputeService(projectName string, namespace string) (*compute.Service, error) {
-	project, err := c.ProjectLister.Projects(namespace).Get(projectName)
+func (c *Controller) ComputeService(project *googleProject) (*compute.Service, error) {
+	client, err := c.NewGoogleClient(project, compute.ComputeScope)
 	if err != nil {
-		return nil, fmt.Errorf("error getting project '%s-%s': %s", namespace, projectName, err.Error())
+		return nil, err
 	}
 
-	secret, err := c.Kubernetes.CoreV1().Secrets(namespace).Get(project.Spec.ServiceAccountSecret, metav1.GetOptions{})
+	comp, err := compute.New(client)
 	if err != nil {
-		return nil, fmt.Errorf("error getting secret '%s-%s' for serviceaccount of project '%s-%s': %s", namespace, project.Spec.ServiceAccountSecret, namespace, projectName, err.Error())
+		return nil, fmt.Errorf("error creating compute client for project '%s': %s", project, err.Error())
 	}
 
-	cred := secret.Data["json"]
-	if len(cred) == 0 {
-		return nil, fmt.Errorf("secret '%s-%s' for serviceaccount of project '%s-%s' does not contain a field 'json'", namespace, project.Spec.ServiceAccountSecret, namespace, projectName)
-	}
+	return comp, nil
+}
 
-	conf, err := google.JWTConfigFromJSON(cred, "https://www.googleapis.com/auth/compute")
+func (c *Controller) SqladminService(project *googleProject) (*sqladmin.Service, error) {
+	client, err := c.NewGoogleClient(project, sqladmin.SqlserviceAdminScope)
 	if err != nil {
-		return nil, fmt.Errorf("error creating authentication for project '%s-%s': %s", namespace, projectName, err.Error())
+		return nil, err
 	}
 
-	client := conf.Client(oauth2.NoContext)
-
-	comp, err := compute.New(client)
+	sqla, err := sqladmin.New(client)
 	if err != nil {
-		return nil, fmt.Errorf("error creating compute client for project '%s-%s': %s", namespace, projectName, err.Error())
+		return nil, fmt.Errorf("error creating sqladmin client for project '%s': %s", project, err.Error())
 	}
 
-	return comp, nil
+	return sqla, nil
 }
 
-func (c *Controller) SqladminService(projectName string, namespace string) (*sqladmin.Service, error) {
-	client, err := c.NewGoogleClient(projectName, namespace, "https://www.googleapis.com/auth/sqlservice.admin")
+func (c *Controller) ResourceManagerService(project *googleProject) (*cloudresourcemanager.Service, error) {
+	client, err := c.NewGoogleClient(project, cloudresourcemanager.CloudPlatformReadOnlyScope)
 	if err != nil {
 		return nil, err
 	}
 
-	sqla, err := sqladmin.New(client)
+	crm, err := cloudresourcemanager.New(client)
 	if err != nil {
-		return nil, fmt.Errorf("error creating sqladmin client for project '%s-%s': %s", namespace, projectName, err.Error())
+		return nil, fmt.Errorf("error creating resource manager client for project '%s': %s", project, err.Error())
 	}
 
-	return sqla, nil
+	return crm, nil
+}
+
+// How long the same event about an object is not emitted again.
+const eventRepeatInterval = time.Hour
+
+// eventCache remembers when events were emitted, so that they are not repeated every time a
+// failing object is processed.
+type eventCache struct {
+	sync.Mutex
+	emitted map[string]time.Time
+}
+
+func (ec *eventCache) shouldEmit(key string, now time.Time) bool {
+	ec.Lock()
+	defer ec.Unlock()
+
+	if ec.emitted == nil {
+		ec.emitted = map[string]time.Time{}
+	}
+
+	for k, t := range ec.emitted {
+		if now.Sub(t) >= eventRepeatInterval {
+			delete(ec.emitted, k)
+		}
+	}
+
+	if _, ok := ec.emitted[key]; ok {
+		return false
+	}
+	ec.emitted[key] = now
+	return true
 }
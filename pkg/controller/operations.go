@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/sqladmin/v1beta4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The name of a Google Cloud operation the controller is waiting for is stored in an
+// annotation on the object, so it survives restarts of the controller.
+const operationAnnotation = "google.cloudcrd.weisnix.org/operation"
+
+// How long to wait before checking on a pending operation again.
+const operationPollInterval = 10 * time.Second
+
+func pendingOperation(meta *metav1.ObjectMeta) string {
+	return meta.Annotations[operationAnnotation]
+}
+
+func setPendingOperation(meta *metav1.ObjectMeta, name string) {
+	if name == "" {
+		delete(meta.Annotations, operationAnnotation)
+		return
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[operationAnnotation] = name
+}
+
+func computeOperationError(op *compute.Operation) string {
+	if op.Error == nil {
+		return ""
+	}
+	var messages []string
+	for _, e := range op.Error.Errors {
+		messages = append(messages, e.Code+": "+e.Message)
+	}
+	return strings.Join(messages, ", ")
+}
+
+func sqladminOperationError(op *sqladmin.Operation) string {
+	if op.Error == nil {
+		return ""
+	}
+	var messages []string
+	for _, e := range op.Error.Errors {
+		messages = append(messages, e.Code+": "+e.Message)
+	}
+	return strings.Join(messages, ", ")
+}
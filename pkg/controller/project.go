@@ -1,13 +1,265 @@
 package main
 
 import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 
 	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
 )
 
 func (c *Controller) ProjectCreatedOrUpdated(project *googlev1.Project) error {
 	log.Debugf("processing created or updated project '%s/%s'", project.Namespace, project.Name)
+
+	project = project.DeepCopy()
+	status := project.Status.DeepCopy()
+
+	if project.DeletionTimestamp != nil {
+		gp := &googleProject{Kind: "Project", Name: project.Name, Namespace: project.Namespace, Spec: &project.Spec}
+		return c.handleError(c.ProjectQueue, &project.ObjectMeta, "Project", c.finalizeProject(gp, &project.ObjectMeta, func() error {
+			return c.updateProject(project)
+		}))
+	}
+
+	err := c.reconcileProject(project)
+
+	setSynced(&project.Status.ResourceStatus, project.Generation, err)
+	if !equality.Semantic.DeepEqual(status, &project.Status) {
+		if _, serr := c.GoogleClient.GoogleV1().Projects(project.Namespace).UpdateStatus(project); serr != nil {
+			log.Errorf("error updating status of project '%s/%s': %s", project.Namespace, project.Name, serr.Error())
+			if err == nil {
+				err = serr
+			}
+		}
+	}
+
+	return c.handleError(c.ProjectQueue, &project.ObjectMeta, "Project", err)
+}
+
+// The permissions the service account of a project needs for everything this controller
+// does. They are checked with testIamPermissions when the project is reconciled.
+var requiredPermissions = []string{
+	"compute.instances.create",
+	"compute.instances.delete",
+	"compute.instances.get",
+	"compute.instances.resume",
+	"compute.instances.setDeletionProtection",
+	"compute.instances.setLabels",
+	"compute.instances.setMachineType",
+	"compute.instances.setMetadata",
+	"compute.instances.setScheduling",
+	"compute.instances.setServiceAccount",
+	"compute.instances.setTags",
+	"compute.instances.start",
+	"compute.instances.stop",
+	"compute.instances.suspend",
+	"compute.disks.get",
+	"compute.disks.resize",
+	"compute.addresses.get",
+	"compute.addresses.use",
+	"compute.images.getFromFamily",
+	"compute.subnetworks.use",
+	"compute.subnetworks.useExternalIp",
+	"compute.zoneOperations.get",
+	"compute.regions.get",
+	"compute.zones.get",
+	"cloudsql.instances.create",
+	"cloudsql.instances.delete",
+	"cloudsql.instances.get",
+	"cloudsql.instances.list",
+	"cloudsql.instances.update",
+	"cloudsql.users.update",
+	"iam.serviceAccounts.actAs",
+}
+
+func (c *Controller) reconcileProject(project *googlev1.Project) error {
+	if !hasFinalizer(&project.ObjectMeta) {
+		addFinalizer(&project.ObjectMeta)
+		if err := c.updateProject(project); err != nil {
+			return err
+		}
+	}
+
+	return c.verifyProject(&googleProject{
+		Kind:      "Project",
+		Name:      project.Name,
+		Namespace: project.Namespace,
+		Spec:      &project.Spec,
+	}, &project.ObjectMeta, &project.Status)
+}
+
+// How long the result of checking a project against Google Cloud is trusted if neither its
+// spec nor its credentials change.
+const projectVerifyInterval = time.Hour
+
+// verifyProject checks the spec of a Project or ClusterProject against Google Cloud and
+// records the result in its status. As every resync processes the project again, the check
+// is skipped if it was done for the same generation and credentials within
+// projectVerifyInterval, and its error returned again.
+func (c *Controller) verifyProject(gp *googleProject, meta *metav1.ObjectMeta, status *googlev1.ProjectStatus) error {
+	version, verr := c.credentialsVersion(gp)
+	if verr == nil && status.VerifiedAt != nil && status.VerifiedGeneration == meta.Generation && status.VerifiedCredentials == version && time.Since(status.VerifiedAt.Time) < projectVerifyInterval {
+		if status.LastError != "" {
+			return NewPermanentError("%s", status.LastError)
+		}
+		return nil
+	}
+
+	// Only results that would not change by trying again are recorded.
+	status.VerifiedAt = nil
+	err := c.checkProject(gp, meta, status)
+	if verr == nil && (err == nil || IsPermanentError(err)) {
+		now := metav1.Now()
+		status.VerifiedAt = &now
+		status.VerifiedGeneration = meta.Generation
+		status.VerifiedCredentials = version
+	}
+	return err
+}
+
+// checkProject checks the credentials, permissions, region and zone of a project.
+func (c *Controller) checkProject(gp *googleProject, meta *metav1.ObjectMeta, status *googlev1.ProjectStatus) error {
+	spec := gp.Spec
+
+	if spec.Name == "" || spec.Region == "" || spec.Zone == "" {
+		message := fmt.Sprintf("project '%s' needs a name, region and zone", gp.Name)
+		setReady(&status.ResourceStatus, false, "InvalidSpec", message)
+		return NewPermanentError("%s", message)
+	}
+
+	if err := validateCredentials(gp.Kind, projectCredentials(spec)); err != nil {
+		message := fmt.Sprintf("project '%s' has invalid credentials: %s", gp.Name, err.Error())
+		setReady(&status.ResourceStatus, false, "InvalidSpec", message)
+		return NewPermanentError("%s", message)
+	}
+
+	crm, err := c.ResourceManagerService(gp)
+	if err != nil {
+		setReady(&status.ResourceStatus, false, "InvalidCredentials", err.Error())
+		return err
+	}
+
+	p, err := crm.Projects.Get(spec.Name).Do()
+	if err != nil {
+		message := fmt.Sprintf("could not get project '%s': %s", spec.Name, err.Error())
+		setReady(&status.ResourceStatus, false, "ProjectNotAccessible", message)
+		return keepPermanent(err, c.MakeEventAndFail(meta, gp.Kind, message))
+	}
+
+	status.ID = p.ProjectId
+	status.ProjectNumber = strconv.FormatInt(p.ProjectNumber, 10)
+
+	perms, err := crm.Projects.TestIamPermissions(spec.Name, &cloudresourcemanager.TestIamPermissionsRequest{
+		Permissions: requiredPermissions,
+	}).Do()
+	if err != nil {
+		return fmt.Errorf("error testing permissions on project '%s': %s", spec.Name, err.Error())
+	}
+
+	granted := make(map[string]bool, len(perms.Permissions))
+	for _, perm := range perms.Permissions {
+		granted[perm] = true
+	}
+
+	var missing []string
+	for _, perm := range requiredPermissions {
+		if !granted[perm] {
+			missing = append(missing, perm)
+		}
+	}
+	sort.Strings(missing)
+	previous := status.MissingPermissions
+	status.MissingPermissions = missing
+
+	comp, err := c.ComputeService(gp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := comp.Regions.Get(spec.Name, spec.Region).Do(); err != nil {
+		if isNotFound(err) {
+			message := fmt.Sprintf("region '%s' does not exist in project '%s'", spec.Region, spec.Name)
+			setReady(&status.ResourceStatus, false, "InvalidRegion", message)
+			return NewPermanentError("%s", message)
+		}
+		return fmt.Errorf("error getting region '%s': %s", spec.Region, err.Error())
+	}
+
+	zone, err := comp.Zones.Get(spec.Name, spec.Zone).Do()
+	if err != nil {
+		if isNotFound(err) {
+			message := fmt.Sprintf("zone '%s' does not exist in project '%s'", spec.Zone, spec.Name)
+			setReady(&status.ResourceStatus, false, "InvalidZone", message)
+			return NewPermanentError("%s", message)
+		}
+		return fmt.Errorf("error getting zone '%s': %s", spec.Zone, err.Error())
+	}
+
+	if path.Base(zone.Region) != spec.Region {
+		message := fmt.Sprintf("zone '%s' is not in region '%s'", spec.Zone, spec.Region)
+		setReady(&status.ResourceStatus, false, "InvalidZone", message)
+		return NewPermanentError("%s", message)
+	}
+
+	if len(missing) > 0 {
+		message := fmt.Sprintf("service account is missing permissions: %s", strings.Join(missing, ", "))
+		setReady(&status.ResourceStatus, false, "MissingPermissions", message)
+		if !equality.Semantic.DeepEqual(missing, previous) {
+			_ = c.MakeEvent(meta, gp.Kind, message, true)
+		}
+		return nil
+	}
+
+	setReady(&status.ResourceStatus, true, "Verified", "")
+	return nil
+}
+
+// How often a deleted project checks whether it is still used.
+const projectInUsePollInterval = 30 * time.Second
+
+// finalizeProject removes the finalizer of a deleted Project or ClusterProject once no
+// Instance or Database needs its credentials to be cleaned up anymore. update writes the
+// object.
+func (c *Controller) finalizeProject(gp *googleProject, meta *metav1.ObjectMeta, update func() error) error {
+	if !hasFinalizer(meta) {
+		return nil
+	}
+
+	dependents, err := c.projectDependents(gp)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		message := fmt.Sprintf("%s '%s' is still used by %s, waiting for them to be deleted", strings.ToLower(gp.Kind), gp.Name, strings.Join(dependents, ", "))
+		_ = c.MakeEvent(meta, gp.Kind, message, true)
+		return RequeueAfter(projectInUsePollInterval, "%s", message)
+	}
+
+	removeFinalizer(meta)
+	return update()
+}
+
+// updateProject writes the metadata and spec of the project and refreshes the object in
+// place. The status is kept, it is written separately.
+func (c *Controller) updateProject(project *googlev1.Project) error {
+	updated, err := c.GoogleClient.GoogleV1().Projects(project.Namespace).Update(project)
+	if err != nil {
+		return fmt.Errorf("error updating project '%s/%s': %s", project.Namespace, project.Name, err.Error())
+	}
+
+	status := project.Status
+	*project = *updated
+	project.Status = status
 	return nil
 }
 
@@ -16,3 +268,131 @@ func (c *Controller) ProjectDeleted(project *googlev1.Project) error {
 	return nil
 }
 
+// googleProject is a Project or a ClusterProject, as used by Instances and Databases.
+type googleProject struct {
+	// "Project" or "ClusterProject"
+	Kind string
+	Name string
+	// The namespace of a Project, empty for a ClusterProject.
+	Namespace string
+	Spec      *googlev1.ProjectSpec
+
+	credentialsNamespace string
+}
+
+func (p *googleProject) String() string {
+	if p.Namespace == "" {
+		return p.Name
+	}
+	return p.Namespace + "-" + p.Name
+}
+
+// SecretNamespace is the namespace the Secret with the credentials of the project is read
+// from.
+func (p *googleProject) SecretNamespace() string {
+	if p.Kind == "ClusterProject" {
+		return p.credentialsNamespace
+	}
+	return p.Namespace
+}
+
+// getProject returns the project with the given name for an object in namespace. A Project
+// in the namespace takes precedence over a ClusterProject of the same name. A ClusterProject
+// can only be used if it allows the namespace, otherwise a permanent error is returned.
+func (c *Controller) getProject(namespace, name string) (*googleProject, error) {
+	project, err := c.ProjectLister.Projects(namespace).Get(name)
+	if err == nil {
+		return &googleProject{Kind: "Project", Name: project.Name, Namespace: project.Namespace, Spec: &project.Spec}, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("error getting project '%s-%s': %s", namespace, name, err.Error())
+	}
+
+	clusterProject, err := c.ClusterProjectLister.Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("there is neither a project '%s-%s' nor a clusterproject '%s'", namespace, name, name)
+		}
+		return nil, fmt.Errorf("error getting clusterproject '%s': %s", name, err.Error())
+	}
+
+	allowed, err := c.namespaceAllowed(clusterProject, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, NewPermanentError("namespace '%s' is not allowed to use clusterproject '%s'", namespace, name)
+	}
+
+	return c.clusterGoogleProject(clusterProject), nil
+}
+
+// Annotations that choose the project of objects that do not set spec.project.
+const (
+	// On a Namespace, the name of the project to use.
+	defaultProjectAnnotation = "google.cloudcrd.weisnix.org/default-project"
+	// On a Project, "true" if it is the default in its namespace.
+	isDefaultProjectAnnotation = "google.cloudcrd.weisnix.org/is-default-project"
+)
+
+// defaultProjectName returns the project for objects in namespace that do not set
+// spec.project. It is taken from the annotation of the namespace, then from the Project in
+// the namespace marked as default, and finally from the -default-project flag.
+func (c *Controller) defaultProjectName(namespace string) (string, error) {
+	ns, err := c.NamespaceLister.Get(namespace)
+	if err != nil {
+		return "", fmt.Errorf("error getting namespace '%s': %s", namespace, err.Error())
+	}
+	if name := ns.Annotations[defaultProjectAnnotation]; name != "" {
+		return name, nil
+	}
+
+	projects, err := c.ProjectLister.Projects(namespace).List(labels.Everything())
+	if err != nil {
+		return "", fmt.Errorf("error listing projects in namespace '%s': %s", namespace, err.Error())
+	}
+
+	var defaults []string
+	for _, project := range projects {
+		if project.Annotations[isDefaultProjectAnnotation] == "true" {
+			defaults = append(defaults, project.Name)
+		}
+	}
+	sort.Strings(defaults)
+
+	switch {
+	case len(defaults) == 1:
+		return defaults[0], nil
+	case len(defaults) > 1:
+		return "", NewPermanentError("more than one project in namespace '%s' is marked as default: %s", namespace, strings.Join(defaults, ", "))
+	case c.DefaultProject != "":
+		return c.DefaultProject, nil
+	}
+
+	return "", NewPermanentError("spec.project is not set and there is no default project for namespace '%s'", namespace)
+}
+
+// resolveProject returns the project an object uses, given the name it references. An empty
+// name means the default project of the namespace. If the project can not be used because
+// of its spec, e.g. because the namespace is not allowed to use a ClusterProject, a Warning
+// event is emitted on the object.
+func (c *Controller) resolveProject(meta *metav1.ObjectMeta, kind string, name string) (*googleProject, error) {
+	var err error
+	if name == "" {
+		name, err = c.defaultProjectName(meta.Namespace)
+	}
+
+	var project *googleProject
+	if err == nil {
+		project, err = c.getProject(meta.Namespace, name)
+	}
+
+	if err != nil {
+		if IsPermanentError(err) {
+			_ = c.MakeEvent(meta, kind, err.Error(), true)
+		}
+		return nil, err
+	}
+
+	return project, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+// How far evaluateSchedule looks back for the last start and stop, from short to long, so
+// that frequent schedules do not have to be iterated over a whole year.
+var scheduleLookbacks = []time.Duration{
+	time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	32 * 24 * time.Hour,
+	366 * 24 * time.Hour,
+}
+
+// evaluateSchedule returns whether a resource should be running at the given time, and when
+// the schedule starts or stops it next. It is running if it was started more recently than
+// stopped, or if the schedule has not done either within the last year. All errors are
+// permanent.
+func evaluateSchedule(schedule *googlev1.Schedule, now time.Time) (running bool, next time.Time, err error) {
+	loc := time.UTC
+	if schedule.TimeZone != "" {
+		loc, err = time.LoadLocation(schedule.TimeZone)
+		if err != nil {
+			return false, time.Time{}, NewPermanentError("invalid time zone '%s' in schedule: %s", schedule.TimeZone, err.Error())
+		}
+	}
+	now = now.In(loc)
+
+	start, err := cron.ParseStandard(schedule.Start)
+	if err != nil {
+		return false, time.Time{}, NewPermanentError("invalid start '%s' in schedule: %s", schedule.Start, err.Error())
+	}
+	stop, err := cron.ParseStandard(schedule.Stop)
+	if err != nil {
+		return false, time.Time{}, NewPermanentError("invalid stop '%s' in schedule: %s", schedule.Stop, err.Error())
+	}
+
+	lastStart, lastStop := lastActivation(start, now), lastActivation(stop, now)
+	running = !lastStart.Before(lastStop)
+
+	next = start.Next(now)
+	if n := stop.Next(now); !n.IsZero() && (next.IsZero() || n.Before(next)) {
+		next = n
+	}
+
+	return running, next, nil
+}
+
+// lastActivation returns the last time before or at now the schedule fired, or the zero time
+// if it did not within the longest lookback.
+func lastActivation(schedule cron.Schedule, now time.Time) time.Time {
+	for _, lookback := range scheduleLookbacks {
+		t := schedule.Next(now.Add(-lookback))
+		if t.IsZero() || t.After(now) {
+			continue
+		}
+		for {
+			n := schedule.Next(t)
+			if n.IsZero() || n.After(now) {
+				return t
+			}
+			t = n
+		}
+	}
+	return time.Time{}
+}
+
+// requeueForSchedule asks for an object to be processed again when its schedule next starts
+// or stops it.
+func requeueForSchedule(kind, name string, next time.Time) error {
+	if next.IsZero() {
+		return nil
+	}
+	return RequeueAfter(time.Until(next)+time.Second, "waiting for the next scheduled start or stop of %s '%s' at %s", kind, name, next.Format(time.RFC3339))
+}
+
+// scheduledMessage adds to the message of an event that it happens because of the schedule.
+func scheduledMessage(message string, schedule *googlev1.Schedule) string {
+	if schedule == nil {
+		return message
+	}
+	return fmt.Sprintf("%s, as scheduled", message)
+}
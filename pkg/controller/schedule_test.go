@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robfig/cron"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+func date(t *testing.T, value string) time.Time {
+	d, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("invalid time '%s': %s", value, err)
+	}
+	return d
+}
+
+func TestEvaluateSchedule(t *testing.T) {
+	workdays := googlev1.Schedule{Start: "0 8 * * 1-5", Stop: "0 18 * * 1-5"}
+
+	tests := []struct {
+		name     string
+		schedule googlev1.Schedule
+		now      string
+		running  bool
+		next     string
+	}{
+		// 2024-01-10 is a Wednesday.
+		{"during the day", workdays, "2024-01-10T12:00:00Z", true, "2024-01-10T18:00:00Z"},
+		{"in the evening", workdays, "2024-01-10T20:00:00Z", false, "2024-01-11T08:00:00Z"},
+		{"before the first start of the week", workdays, "2024-01-08T07:59:00Z", false, "2024-01-08T08:00:00Z"},
+		{"at the start", workdays, "2024-01-10T08:00:00Z", true, "2024-01-10T18:00:00Z"},
+		{"at the stop", workdays, "2024-01-10T18:00:00Z", false, "2024-01-11T08:00:00Z"},
+		{"on the weekend", workdays, "2024-01-13T12:00:00Z", false, "2024-01-15T08:00:00Z"},
+		{"start and stop at the same time", googlev1.Schedule{Start: "0 8 * * *", Stop: "0 8 * * *"}, "2024-01-10T12:00:00Z", true, "2024-01-11T08:00:00Z"},
+		{"in another time zone", googlev1.Schedule{Start: "0 8 * * *", Stop: "0 18 * * *", TimeZone: "Europe/Berlin"}, "2024-01-10T06:30:00Z", false, "2024-01-10T07:00:00Z"},
+		{"started in another time zone", googlev1.Schedule{Start: "0 8 * * *", Stop: "0 18 * * *", TimeZone: "Europe/Berlin"}, "2024-01-10T07:30:00Z", true, "2024-01-10T17:00:00Z"},
+		{"monthly", googlev1.Schedule{Start: "0 0 1 * *", Stop: "0 0 15 * *"}, "2024-03-10T00:00:00Z", true, "2024-03-15T00:00:00Z"},
+		{"never started", googlev1.Schedule{Start: "0 0 30 2 *", Stop: "0 18 * * *"}, "2024-01-10T20:00:00Z", false, "2024-01-11T18:00:00Z"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			running, next, err := evaluateSchedule(&test.schedule, date(t, test.now))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if running != test.running {
+				t.Errorf("running is %t, expected %t", running, test.running)
+			}
+			if expected := date(t, test.next); !next.Equal(expected) {
+				t.Errorf("next is %s, expected %s", next.UTC().Format(time.RFC3339), test.next)
+			}
+		})
+	}
+}
+
+func TestEvaluateScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule googlev1.Schedule
+	}{
+		{"invalid time zone", googlev1.Schedule{Start: "0 8 * * *", Stop: "0 18 * * *", TimeZone: "Mars/Olympus_Mons"}},
+		{"invalid start", googlev1.Schedule{Start: "0 8 * *", Stop: "0 18 * * *"}},
+		{"invalid stop", googlev1.Schedule{Start: "0 8 * * *", Stop: "at six"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := evaluateSchedule(&test.schedule, time.Now())
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !IsPermanentError(err) {
+				t.Errorf("error '%s' is not permanent", err)
+			}
+		})
+	}
+}
+
+func TestLastActivation(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		now      string
+		last     string
+	}{
+		{"within the hour", "*/15 * * * *", "2024-01-10T10:20:00Z", "2024-01-10T10:15:00Z"},
+		{"at the activation", "0 8 * * *", "2024-01-10T08:00:00Z", "2024-01-10T08:00:00Z"},
+		{"within the day", "0 8 * * *", "2024-01-10T07:00:00Z", "2024-01-09T08:00:00Z"},
+		{"within the week", "0 8 * * 1", "2024-01-10T07:00:00Z", "2024-01-08T08:00:00Z"},
+		{"within the month", "0 0 1 * *", "2024-03-20T00:00:00Z", "2024-03-01T00:00:00Z"},
+		{"within the year", "0 0 1 1 *", "2024-06-01T00:00:00Z", "2024-01-01T00:00:00Z"},
+		{"longer ago than a year", "0 0 29 2 *", "2025-06-01T00:00:00Z", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := cron.ParseStandard(test.schedule)
+			if err != nil {
+				t.Fatalf("invalid schedule '%s': %s", test.schedule, err)
+			}
+
+			last := lastActivation(schedule, date(t, test.now))
+			if test.last == "" {
+				if !last.IsZero() {
+					t.Errorf("last activation is %s, expected none", last.Format(time.RFC3339))
+				}
+				return
+			}
+			if expected := date(t, test.last); !last.Equal(expected) {
+				t.Errorf("last activation is %s, expected %s", last.Format(time.RFC3339), test.last)
+			}
+		})
+	}
+}
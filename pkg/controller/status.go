@@ -0,0 +1,60 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	googlev1 "github.com/iljaweis/kube-cloud-crd-google/pkg/apis/google.cloudcrd.weisnix.org/v1"
+)
+
+func getCondition(status *googlev1.ResourceStatus, t googlev1.ConditionType) *googlev1.Condition {
+	for i := range status.Conditions {
+		if status.Conditions[i].Type == t {
+			return &status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// setCondition sets a condition on the status. The transition time is only updated when
+// the status of the condition changes.
+func setCondition(status *googlev1.ResourceStatus, t googlev1.ConditionType, s corev1.ConditionStatus, reason, message string) {
+	cond := getCondition(status, t)
+	if cond == nil {
+		status.Conditions = append(status.Conditions, googlev1.Condition{Type: t})
+		cond = &status.Conditions[len(status.Conditions)-1]
+	}
+
+	if cond.Status != s {
+		cond.Status = s
+		cond.LastTransitionTime = metav1.Now()
+	}
+	cond.Reason = reason
+	cond.Message = message
+}
+
+func setReady(status *googlev1.ResourceStatus, ready bool, reason, message string) {
+	if ready {
+		setCondition(status, googlev1.ConditionReady, corev1.ConditionTrue, reason, message)
+	} else {
+		setCondition(status, googlev1.ConditionReady, corev1.ConditionFalse, reason, message)
+	}
+}
+
+// setSynced records the outcome of a reconcile. A RequeueError is not a failure.
+func setSynced(status *googlev1.ResourceStatus, generation int64, err error) {
+	status.ObservedGeneration = generation
+
+	if _, requeue := err.(*RequeueError); err == nil || requeue {
+		status.LastError = ""
+		setCondition(status, googlev1.ConditionSynced, corev1.ConditionTrue, "ReconcileSuccess", "")
+		return
+	}
+
+	status.LastError = err.Error()
+	if IsPermanentError(err) {
+		setCondition(status, googlev1.ConditionSynced, corev1.ConditionFalse, "InvalidSpec", err.Error())
+	} else {
+		setCondition(status, googlev1.ConditionSynced, corev1.ConditionFalse, "ReconcileError", err.Error())
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 	kubernetesinformers "k8s.io/client-go/informers"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
 
 
 
@@ -34,6 +35,15 @@ type Controller struct {
 	Kubernetes kubernetes.Interface
 	KubernetesFactory kubernetesinformers.SharedInformerFactory
 
+	SecretLister corelisterv1.SecretLister
+	SecretSynced cache.InformerSynced
+
+	NamespaceLister corelisterv1.NamespaceLister
+	NamespaceSynced cache.InformerSynced
+
+	ConfigMapLister corelisterv1.ConfigMapLister
+	ConfigMapSynced cache.InformerSynced
+
 
 
 	GoogleClient googleclientset.Interface
@@ -45,6 +55,10 @@ type Controller struct {
 	ProjectLister googlelisterv1.ProjectLister
 	ProjectSynced cache.InformerSynced
 
+	ClusterProjectQueue workqueue.RateLimitingInterface
+	ClusterProjectLister googlelisterv1.ClusterProjectLister
+	ClusterProjectSynced cache.InformerSynced
+
 	InstanceQueue workqueue.RateLimitingInterface
 	InstanceLister googlelisterv1.InstanceLister
 	InstanceSynced cache.InformerSynced
@@ -53,6 +67,24 @@ type Controller struct {
 	DatabaseLister googlelisterv1.DatabaseLister
 	DatabaseSynced cache.InformerSynced
 
+	// MaxRetries is the number of times a failing key is requeued with backoff
+	// before it is dropped until the next resync.
+	MaxRetries int
+
+	// CredentialsNamespace is the namespace the Secrets of ClusterProjects are read from.
+	CredentialsNamespace string
+
+	// DefaultProject is used by objects that do not set spec.project when neither their
+	// namespace nor a Project in it name a default.
+	DefaultProject string
+
+	// ExpiryWarning is how long before an object expires a Warning event is emitted.
+	ExpiryWarning time.Duration
+
+	credentials credentialCache
+
+	events eventCache
+
 
 
 
@@ -67,6 +99,18 @@ func (c *Controller) Initialize() {
 	}
 	c.KubernetesFactory = kubernetesinformers.NewSharedInformerFactory(c.Kubernetes, time.Second*30)
 
+	SecretInformer := c.KubernetesFactory.Core().V1().Secrets()
+	c.SecretLister = SecretInformer.Lister()
+	c.SecretSynced = SecretInformer.Informer().HasSynced
+
+	NamespaceInformer := c.KubernetesFactory.Core().V1().Namespaces()
+	c.NamespaceLister = NamespaceInformer.Lister()
+	c.NamespaceSynced = NamespaceInformer.Informer().HasSynced
+
+	ConfigMapInformer := c.KubernetesFactory.Core().V1().ConfigMaps()
+	c.ConfigMapLister = ConfigMapInformer.Lister()
+	c.ConfigMapSynced = ConfigMapInformer.Informer().HasSynced
+
 
 
 	if c.GoogleClient == nil {
@@ -126,6 +170,55 @@ func (c *Controller) Initialize() {
 
 
 
+	ClusterProjectInformer := c.GoogleFactory.Google().V1().ClusterProjects()
+	ClusterProjectQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	c.ClusterProjectQueue = ClusterProjectQueue
+	c.ClusterProjectLister = ClusterProjectInformer.Lister()
+	c.ClusterProjectSynced = ClusterProjectInformer.Informer().HasSynced
+
+	ClusterProjectInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+
+		AddFunc: func(obj interface{}) {
+			if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+				ClusterProjectQueue.Add(key)
+			}
+		},
+
+
+		UpdateFunc: func(old, new interface{}) {
+			if key, err := cache.MetaNamespaceKeyFunc(new); err == nil {
+				ClusterProjectQueue.Add(key)
+			}
+		},
+
+
+		DeleteFunc: func(obj interface{}) {
+			o, ok := obj.(*googlev1.ClusterProject)
+
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					log.Errorf("couldn't get object from tombstone %+v", obj)
+					return
+				}
+				o, ok = tombstone.Obj.(*googlev1.ClusterProject)
+				if !ok {
+					log.Errorf("tombstone contained object that is not a ClusterProject %+v", obj)
+					return
+				}
+			}
+
+			err := c.ClusterProjectDeleted(o)
+
+			if err != nil {
+				log.Errorf("failed to process deletion: %s", err.Error())
+			}
+		},
+
+	})
+
+
+
 	InstanceInformer := c.GoogleFactory.Google().V1().Instances()
 	InstanceQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 	c.InstanceQueue = InstanceQueue
@@ -250,10 +343,11 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 	defer runtime.HandleCrash()
 
 	defer c.ProjectQueue.ShutDown()
+	defer c.ClusterProjectQueue.ShutDown()
 	defer c.InstanceQueue.ShutDown()
 	defer c.DatabaseQueue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, c.ProjectSynced, c.InstanceSynced, c.DatabaseSynced) {
+	if !cache.WaitForCacheSync(stopCh, c.SecretSynced, c.NamespaceSynced, c.ConfigMapSynced, c.ProjectSynced, c.ClusterProjectSynced, c.InstanceSynced, c.DatabaseSynced) {
 		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
 		return
 	}
@@ -263,6 +357,8 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 
 	go wait.Until(c.runProjectWorker, time.Second, stopCh)
 
+	go wait.Until(c.runClusterProjectWorker, time.Second, stopCh)
+
 	go wait.Until(c.runInstanceWorker, time.Second, stopCh)
 
 	go wait.Until(c.runDatabaseWorker, time.Second, stopCh)
@@ -333,6 +429,64 @@ func (c *Controller) processProject(key string) error {
 
 }
 
+func (c *Controller) runClusterProjectWorker() {
+	for c.processNextClusterProject() {
+	}
+}
+
+func (c *Controller) processNextClusterProject() bool {
+	obj, shutdown := c.ClusterProjectQueue.Get()
+	if shutdown {
+		return false
+	}
+
+	err := func(obj interface{}) error {
+		defer c.ClusterProjectQueue.Done(obj)
+		var key string
+		var ok bool
+
+		if key, ok = obj.(string); !ok {
+			c.ClusterProjectQueue.Forget(obj)
+			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+			return nil
+		}
+
+		if err := c.processClusterProject(key); err != nil {
+			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
+		}
+
+		c.ClusterProjectQueue.Forget(obj)
+		return nil
+	}(obj)
+
+	if err != nil {
+		runtime.HandleError(err)
+		return true
+	}
+
+	return true
+}
+
+func (c *Controller) processClusterProject(key string) error {
+
+	_, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("could not parse name %s: %s", key, err.Error())
+	}
+
+	o, err := c.ClusterProjectLister.Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return fmt.Errorf("tried to get %s, but it was not found", key)
+		} else {
+			return fmt.Errorf("error getting %s from cache: %s", key, err.Error())
+		}
+	}
+
+	return c.ClusterProjectCreatedOrUpdated(o)
+
+}
+
 func (c *Controller) runInstanceWorker() {
 	for c.processNextInstance() {
 	}
@@ -447,4 +601,33 @@ func (c *Controller) processDatabase(key string) error {
 
 	return c.DatabaseCreatedOrUpdated(o)
 
+}
+
+func (c *Controller) handleDatabaseError(key string, err error) error {
+	if r, ok := err.(*RequeueError); ok {
+		c.DatabaseQueue.Forget(key)
+		c.DatabaseQueue.AddAfter(key, r.After)
+		log.Debugf("%s: %s", key, r.Error())
+		return nil
+	}
+
+	if IsPermanentError(err) {
+		c.DatabaseQueue.Forget(key)
+		return fmt.Errorf("error syncing '%s', not retrying: %s", key, err.Error())
+	}
+
+	if c.DatabaseQueue.NumRequeues(key) < c.MaxRetries {
+		c.DatabaseQueue.AddRateLimited(key)
+		return fmt.Errorf("error syncing '%s', requeuing: %s", key, err.Error())
+	}
+
+	c.DatabaseQueue.Forget(key)
+	message := fmt.Sprintf("giving up on '%s' after %d retries: %s", key, c.MaxRetries, err.Error())
+
+	namespace, name, _ := cache.SplitMetaNamespaceKey(key)
+	if o, gerr := c.DatabaseLister.Databases(namespace).Get(name); gerr == nil {
+		_ = c.MakeEvent(&o.ObjectMeta, "Database", message, true)
+	}
+
+	return fmt.Errorf("%s", message)
 }
\ No newline at end of file